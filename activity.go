@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// recordIssueEvent appends a field change to the history of an issue. Values
+// are stored as text the way the column reads with CAST(… AS TEXT); nil means
+// empty. A status event with no old value marks the creation of the issue.
+func recordIssueEvent(r *http.Request, u *User, projectID, issueID int64, field string, oldValue, newValue *string) {
+	var userID *int64
+	actor, _ := r.Context().Value(auditCredentialKey).(string)
+	if u != nil {
+		userID = &u.ID
+		actor = u.Email
+	}
+	db.Exec(`INSERT INTO issue_events (issue_id, project_id, user_id, actor, field, old_value, new_value)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		issueID, projectID, userID, actor, field, oldValue, newValue)
+}
+
+// issueFieldText reads a column of an issue as recorded in its history.
+func issueFieldText(issueID int64, field string) *string {
+	if !editableIssueFields[field] {
+		return nil
+	}
+	var v *string
+	db.QueryRow("SELECT CAST("+field+" AS TEXT) FROM issues WHERE id = ?", issueID).Scan(&v)
+	return v
+}
+
+func sameValue(a, b *string) bool {
+	return deref(a) == deref(b)
+}
+
+// issueEvents lists the history of an issue, oldest first, with the names of
+// referenced users and milestones resolved.
+func issueEvents(issueID int64) []IssueEvent {
+	rows, err := db.Query(`
+		SELECT e.id, e.issue_id, e.user_id, e.actor, e.field, e.old_value, e.new_value, e.created_at,
+			u.name, u.avatar_path,
+			COALESCE(ou.name, om.name), COALESCE(nu.name, nm.name)
+		FROM issue_events e
+		LEFT JOIN users u ON u.id = e.user_id
+		LEFT JOIN users ou ON e.field = 'assignee_id' AND ou.id = e.old_value
+		LEFT JOIN users nu ON e.field = 'assignee_id' AND nu.id = e.new_value
+		LEFT JOIN milestones om ON e.field = 'milestone_id' AND om.id = e.old_value
+		LEFT JOIN milestones nm ON e.field = 'milestone_id' AND nm.id = e.new_value
+		WHERE e.issue_id = ?
+		ORDER BY e.id`, issueID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var events []IssueEvent
+	for rows.Next() {
+		var e IssueEvent
+		var name, avatar, oldName, newName *string
+		rows.Scan(&e.ID, &e.IssueID, &e.UserID, &e.Actor, &e.Field, &e.OldValue, &e.NewValue, &e.CreatedAt,
+			&name, &avatar, &oldName, &newName)
+		if e.UserID != nil && name != nil {
+			e.User = &User{ID: *e.UserID, Email: e.Actor, Name: *name, AvatarPath: deref(avatar)}
+		}
+		e.OldName = deref(oldName)
+		e.NewName = deref(newName)
+		events = append(events, e)
+	}
+	return events
+}
+
+// statusDurations adds up how long the issue has spent in each status, from
+// its status events. Issues older than the history start in the old value of
+// their first status change, counted from the creation date.
+func statusDurations(issue *Issue, events []IssueEvent, now time.Time) []StatusDuration {
+	totals := map[string]time.Duration{}
+	var order []string
+	add := func(status string, d time.Duration) {
+		if _, ok := totals[status]; !ok {
+			order = append(order, status)
+		}
+		totals[status] += d
+	}
+
+	status, since := "", issue.CreatedAt
+	for _, e := range events {
+		if e.Field != "status" {
+			continue
+		}
+		if status == "" && e.OldValue != nil {
+			status = *e.OldValue
+		}
+		if status != "" {
+			add(status, e.CreatedAt.Sub(since))
+		}
+		status, since = deref(e.NewValue), e.CreatedAt
+	}
+	if status == "" {
+		status = issue.Status
+	}
+	add(status, now.Sub(since))
+
+	out := make([]StatusDuration, 0, len(order))
+	for _, s := range order {
+		if totals[s] < 0 {
+			totals[s] = 0
+		}
+		out = append(out, StatusDuration{Status: s, Duration: totals[s]})
+	}
+	return out
+}
+
+// formatDuration prints a duration the way the history shows it: the two
+// largest units, or "menos de 1 min".
+func formatDuration(d time.Duration) string {
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+	minutes := int(d.Minutes()) % 60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%d d %d h", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%d h %d min", hours, minutes)
+	case minutes > 0:
+		return fmt.Sprintf("%d min", minutes)
+	}
+	return "menos de 1 min"
+}
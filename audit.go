@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// auditCredentialKey carries a printable label for the non-session
+// credential behind a request (API key, personal token or SCIM token).
+const auditCredentialKey contextKey = "audit_credential"
+const apiKeyIDKey contextKey = "api_key_id"
+
+// auditValueLimit caps stored before/after values; markdown pushes can be
+// up to 1MB and the log only needs enough to see what changed.
+const auditValueLimit = 4000
+
+// recordAudit appends an entry for u. When an admin is impersonating u the
+// admin is stored alongside, so the action shows up under both identities.
+func recordAudit(r *http.Request, u *User, action, target string) {
+	recordChange(r, u, 0, action, target, nil, nil)
+}
+
+// recordChange appends an entry scoped to a project (0 for none) with the
+// values before and after the change, stored as JSON. u may be nil for
+// requests authenticated by a project API key or the SCIM token; the
+// credential is taken from the request context.
+func recordChange(r *http.Request, u *User, projectID int64, action, target string, before, after any) {
+	var userID, impersonatorID, apiKeyID, pid *int64
+	var actor string
+	if u != nil {
+		userID = &u.ID
+		actor = u.Email
+		if u.Impersonator != nil {
+			impersonatorID = &u.Impersonator.ID
+		}
+	}
+	if id, ok := r.Context().Value(apiKeyIDKey).(int64); ok {
+		apiKeyID = &id
+	}
+	via, _ := r.Context().Value(auditCredentialKey).(string)
+	if actor == "" {
+		actor = via
+	}
+	if projectID != 0 {
+		pid = &projectID
+	}
+	db.Exec(`INSERT INTO audit_log (user_id, impersonator_id, api_key_id, actor, via, project_id, action, target, before_value, after_value, ip)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, impersonatorID, apiKeyID, actor, via, pid, action, target, auditJSON(before), auditJSON(after), clientIP(r))
+}
+
+func auditJSON(v any) *string {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	s := string(b)
+	return &s
+}
+
+// auditExcerpt shortens long text values before they go into the log.
+func auditExcerpt(s string) string {
+	if len(s) <= auditValueLimit {
+		return s
+	}
+	cut := auditValueLimit
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "… (" + strconv.Itoa(len(s)) + " bytes)"
+}
+
+// clientIP prefers the address reported by the Fly.io proxy when the portal
+// runs behind it; anywhere else a client could set that header itself.
+func clientIP(r *http.Request) string {
+	if ip := strings.TrimSpace(r.Header.Get("Fly-Client-IP")); ip != "" && cfg.BehindFlyProxy {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// auditFilter narrows the audit viewer. Zero values match everything.
+type auditFilter struct {
+	ProjectID int64
+	Actor     string
+	Action    string
+	From      string
+	To        string
+	BeforeID  int64
+}
+
+func auditFilterFromQuery(r *http.Request) auditFilter {
+	q := r.URL.Query()
+	f := auditFilter{
+		Actor:  strings.TrimSpace(q.Get("actor")),
+		Action: strings.TrimSpace(q.Get("action")),
+		From:   q.Get("from"),
+		To:     q.Get("to"),
+	}
+	f.ProjectID, _ = strconv.ParseInt(q.Get("project"), 10, 64)
+	f.BeforeID, _ = strconv.ParseInt(q.Get("before"), 10, 64)
+	return f
+}
+
+const auditPageSize = 100
+
+// auditEntries returns one page of entries, newest first, and the id to pass
+// as BeforeID for the next page (0 when there are no more).
+func auditEntries(f auditFilter) ([]AuditEntry, int64) {
+	query := `
+		SELECT a.id, a.user_id, a.actor, a.via, imp.email, a.project_id, p.name, p.slug,
+			a.action, a.target, a.before_value, a.after_value, a.ip, a.created_at
+		FROM audit_log a
+		LEFT JOIN users imp ON imp.id = a.impersonator_id
+		LEFT JOIN projects p ON p.id = a.project_id
+		WHERE 1 = 1`
+	var args []any
+	if f.ProjectID != 0 {
+		query += ` AND a.project_id = ?`
+		args = append(args, f.ProjectID)
+	}
+	if f.Actor != "" {
+		query += ` AND (a.actor LIKE ? OR imp.email LIKE ?)`
+		like := "%" + f.Actor + "%"
+		args = append(args, like, like)
+	}
+	if f.Action != "" {
+		query += ` AND a.action LIKE ?`
+		args = append(args, f.Action+"%")
+	}
+	if _, err := time.Parse("2006-01-02", f.From); err == nil {
+		query += ` AND a.created_at >= ?`
+		args = append(args, f.From)
+	}
+	if to, err := time.Parse("2006-01-02", f.To); err == nil {
+		query += ` AND a.created_at < ?`
+		args = append(args, to.AddDate(0, 0, 1).Format("2006-01-02"))
+	}
+	if f.BeforeID != 0 {
+		query += ` AND a.id < ?`
+		args = append(args, f.BeforeID)
+	}
+	query += ` ORDER BY a.id DESC LIMIT ?`
+	args = append(args, auditPageSize+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, 0
+	}
+	defer rows.Close()
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var impersonator, pname, pslug *string
+		rows.Scan(&e.ID, &e.UserID, &e.Actor, &e.Via, &impersonator, &e.ProjectID, &pname, &pslug,
+			&e.Action, &e.Target, &e.Before, &e.After, &e.IP, &e.CreatedAt)
+		e.Impersonator = deref(impersonator)
+		if e.ProjectID != nil {
+			e.Project = &Project{ID: *e.ProjectID, Name: deref(pname), Slug: deref(pslug)}
+		}
+		entries = append(entries, e)
+	}
+	var next int64
+	if len(entries) > auditPageSize {
+		entries = entries[:auditPageSize]
+		next = entries[auditPageSize-1].ID
+	}
+	return entries, next
+}
+
+func handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u.Role != "admin" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	f := auditFilterFromQuery(r)
+	entries, next := auditEntries(f)
+	renderTemplate(w, "audit.html", map[string]any{
+		"User":      u,
+		"Projects":  userProjects(u),
+		"Entries":   entries,
+		"Filter":    f,
+		"Next":      next,
+		"Action":    "/admin/audit",
+		"AdminPage": "audit",
+	})
+}
+
+// handleProjectAudit shows the entries of one project to whoever has
+// permAuditView there, owners by default.
+func handleProjectAudit(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	p, access, ok := authorizeProject(w, r, permAuditView)
+	if !ok {
+		return
+	}
+	f := auditFilterFromQuery(r)
+	f.ProjectID = p.ID
+	entries, next := auditEntries(f)
+	renderTemplate(w, "audit.html", map[string]any{
+		"User":     u,
+		"Project":  p,
+		"Projects": userProjects(u),
+		"Access":   access,
+		"Entries":  entries,
+		"Filter":   f,
+		"Next":     next,
+		"Action":   "/projects/" + p.Slug + "/audit",
+	})
+}
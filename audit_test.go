@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func auditCount(t *testing.T, action string) int {
+	t.Helper()
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM audit_log WHERE action = ?", action).Scan(&n)
+	return n
+}
+
+func TestUpdateIssueAuditsStoredChanges(t *testing.T) {
+	openTestDB(t)
+	users := seedProject(t)
+	seedProjectStatuses(1)
+	mustExec(t, "INSERT INTO issues (id, project_id, number, title, status, priority) VALUES (1, 1, 1, 'Login', 'todo', 'medium')")
+	update := func(field, value string) {
+		postAs(handleUpdateIssue, users["member"], url.Values{"field": {field}, "value": {value}}, "slug", "acme", "id", "ACME-1")
+	}
+
+	update("status", "nonexistent")
+	update("priority", "someday")
+	update("title", "Login")
+	update("status", "todo")
+	if n := auditCount(t, "issue.update"); n != 0 {
+		t.Fatalf("%d audit entries for edits that changed nothing", n)
+	}
+
+	update("status", "done")
+	update("priority", "urgent")
+	var before, after string
+	db.QueryRow("SELECT before_value, after_value FROM audit_log WHERE action = 'issue.update' ORDER BY id LIMIT 1").
+		Scan(&before, &after)
+	if n := auditCount(t, "issue.update"); n != 2 || !strings.Contains(before, `"todo"`) || !strings.Contains(after, `"done"`) {
+		t.Errorf("%d entries, first %s -> %s; want the two stored changes", n, before, after)
+	}
+}
+
+func TestUpdateMilestoneAuditsStoredChanges(t *testing.T) {
+	openTestDB(t)
+	users := seedProject(t)
+	mustExec(t, "INSERT INTO milestones (id, project_id, name) VALUES (1, 1, 'Beta')")
+	update := func(field, value string) {
+		postAs(handleUpdateMilestone, users["member"], url.Values{"field": {field}, "value": {value}}, "slug", "acme", "id", "1")
+	}
+
+	update("name", "Beta")
+	update("target_date", "")
+	if n := auditCount(t, "milestone.update"); n != 0 {
+		t.Fatalf("%d audit entries for edits that changed nothing", n)
+	}
+	update("target_date", "2026-12-01")
+	if n := auditCount(t, "milestone.update"); n != 1 {
+		t.Errorf("%d audit entries, want 1", n)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "203.0.113.7:51234"
+	req.Header.Set("Fly-Client-IP", "198.51.100.1")
+
+	cfg.BehindFlyProxy = false
+	if ip := clientIP(req); ip != "203.0.113.7" {
+		t.Errorf("without the Fly proxy clientIP = %s, want the peer address", ip)
+	}
+	cfg.BehindFlyProxy = true
+	if ip := clientIP(req); ip != "198.51.100.1" {
+		t.Errorf("behind the Fly proxy clientIP = %s, want the forwarded address", ip)
+	}
+	req.Header.Del("Fly-Client-IP")
+	if ip := clientIP(req); ip != "203.0.113.7" {
+		t.Errorf("without the header clientIP = %s, want the peer address", ip)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// issueChecklist lists the checklist items of an issue in order.
+func issueChecklist(issueID int64) []ChecklistItem {
+	rows, err := db.Query(`SELECT id, issue_id, body, done, position, created_at
+		FROM issue_checklist_items WHERE issue_id = ? ORDER BY position, id`, issueID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var items []ChecklistItem
+	for rows.Next() {
+		var it ChecklistItem
+		rows.Scan(&it.ID, &it.IssueID, &it.Body, &it.Done, &it.Position, &it.CreatedAt)
+		items = append(items, it)
+	}
+	return items
+}
+
+// handleAddChecklistItem appends one item per non-empty line of body, so a
+// list pasted from the description, "- [x]" marks included, becomes a
+// checklist in one go.
+func handleAddChecklistItem(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	p, _, ok := authorizeProject(w, r, permIssuesEdit)
+	if !ok {
+		return
+	}
+	id := resolveIssueID(p.ID, r.PathValue("id"))
+	issue := projectIssue(p.ID, id)
+	if issue == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	var maxPos int
+	db.QueryRow("SELECT COALESCE(MAX(position), 0) FROM issue_checklist_items WHERE issue_id = ?", id).Scan(&maxPos)
+	for _, line := range strings.Split(r.FormValue("body"), "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "-*+"))
+		done := strings.HasPrefix(line, "[x]") || strings.HasPrefix(line, "[X]")
+		if done || strings.HasPrefix(line, "[ ]") {
+			line = strings.TrimSpace(line[3:])
+		}
+		if line == "" {
+			continue
+		}
+		maxPos++
+		db.Exec("INSERT INTO issue_checklist_items (issue_id, body, done, position) VALUES (?, ?, ?, ?)", id, line, done, maxPos)
+		recordChange(r, u, p.ID, "checklist.add", issue.Title, nil, map[string]any{"issue_id": id, "item": line})
+	}
+	db.Exec("UPDATE issues SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	http.Redirect(w, r, issuePath(p, id)+"#checklist", http.StatusSeeOther)
+}
+
+// handleUpdateChecklistItem ticks, unticks or renames an item.
+func handleUpdateChecklistItem(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	p, _, ok := authorizeProject(w, r, permIssuesEdit)
+	if !ok {
+		return
+	}
+	id := resolveIssueID(p.ID, r.PathValue("id"))
+	itemID := r.PathValue("item")
+
+	var body, title string
+	var done bool
+	err := db.QueryRow(`SELECT c.body, c.done, i.title FROM issue_checklist_items c
+		JOIN issues i ON i.id = c.issue_id
+		WHERE c.id = ? AND c.issue_id = ? AND i.project_id = ?`, itemID, id, p.ID).Scan(&body, &done, &title)
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	newBody, newDone := body, done
+	switch r.FormValue("field") {
+	case "done":
+		newDone = r.FormValue("value") == "1"
+	case "body":
+		if v := strings.TrimSpace(r.FormValue("value")); v != "" {
+			newBody = v
+		}
+	}
+	if newBody != body || newDone != done {
+		db.Exec("UPDATE issue_checklist_items SET body = ?, done = ? WHERE id = ?", newBody, newDone, itemID)
+		db.Exec("UPDATE issues SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+		recordChange(r, u, p.ID, "checklist.update", title,
+			map[string]any{"issue_id": id, "item": body, "done": done},
+			map[string]any{"issue_id": id, "item": newBody, "done": newDone})
+	}
+	http.Redirect(w, r, issuePath(p, id)+"#checklist", http.StatusSeeOther)
+}
+
+func handleDeleteChecklistItem(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	p, _, ok := authorizeProject(w, r, permIssuesEdit)
+	if !ok {
+		return
+	}
+	id := resolveIssueID(p.ID, r.PathValue("id"))
+	itemID := r.PathValue("item")
+
+	var body, title string
+	var done bool
+	err := db.QueryRow(`SELECT c.body, c.done, i.title FROM issue_checklist_items c
+		JOIN issues i ON i.id = c.issue_id
+		WHERE c.id = ? AND c.issue_id = ? AND i.project_id = ?`, itemID, id, p.ID).Scan(&body, &done, &title)
+	if err == nil {
+		db.Exec("DELETE FROM issue_checklist_items WHERE id = ?", itemID)
+		db.Exec("UPDATE issues SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+		recordChange(r, u, p.ID, "checklist.delete", title, map[string]any{"issue_id": id, "item": body, "done": done}, nil)
+	}
+	http.Redirect(w, r, issuePath(p, id)+"#checklist", http.StatusSeeOther)
+}
@@ -15,6 +15,22 @@ type Config struct {
 	DashboardDir string   `json:"dashboard_dir"`
 	ResendAPIKey string   `json:"resend_api_key"`
 	AdminEmails  []string `json:"admin_emails"`
+
+	// OpenID Connect single sign-on (disabled when OIDCIssuer is empty)
+	OIDCIssuer         string   `json:"oidc_issuer"`
+	OIDCClientID       string   `json:"oidc_client_id"`
+	OIDCClientSecret   string   `json:"oidc_client_secret"`
+	OIDCAllowedDomains []string `json:"oidc_allowed_domains"`
+	OIDCAutoProvision  bool     `json:"oidc_auto_provision"`
+
+	// Require admins to complete TOTP after magic-link login
+	RequireAdminTOTP bool `json:"require_admin_totp"`
+
+	// Bearer token for the SCIM 2.0 provisioning endpoints (disabled when empty)
+	SCIMToken string `json:"scim_token"`
+
+	// Trust the Fly-Client-IP header; only set when Fly's proxy is in front
+	BehindFlyProxy bool `json:"behind_fly_proxy"`
 }
 
 var cfg Config
@@ -65,4 +81,36 @@ func loadConfig(path string) {
 	} else if v := os.Getenv("ADMIN_EMAIL"); v != "" {
 		cfg.AdminEmails = []string{v}
 	}
+	if v := os.Getenv("OIDC_ISSUER"); v != "" {
+		cfg.OIDCIssuer = v
+	}
+	if v := os.Getenv("OIDC_CLIENT_ID"); v != "" {
+		cfg.OIDCClientID = v
+	}
+	if v := os.Getenv("OIDC_CLIENT_SECRET"); v != "" {
+		cfg.OIDCClientSecret = v
+	}
+	if v := os.Getenv("OIDC_ALLOWED_DOMAINS"); v != "" {
+		domains := strings.Split(v, ",")
+		for i := range domains {
+			domains[i] = strings.TrimSpace(domains[i])
+		}
+		cfg.OIDCAllowedDomains = domains
+	}
+	if v := os.Getenv("OIDC_AUTO_PROVISION"); v != "" {
+		cfg.OIDCAutoProvision = v == "1" || v == "true"
+	}
+	if v := os.Getenv("REQUIRE_ADMIN_TOTP"); v != "" {
+		cfg.RequireAdminTOTP = v == "1" || v == "true"
+	}
+	if v := os.Getenv("SCIM_TOKEN"); v != "" {
+		cfg.SCIMToken = v
+	}
+	if v := os.Getenv("BEHIND_FLY_PROXY"); v != "" {
+		cfg.BehindFlyProxy = v == "1" || v == "true"
+	}
+}
+
+func oidcEnabled() bool {
+	return cfg.OIDCIssuer != "" && cfg.OIDCClientID != ""
 }
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// customFieldTypes are the kinds of project-defined issue fields. Values are
+// stored as text: numbers in their shortest form, dates as YYYY-MM-DD, users
+// by id.
+var customFieldTypes = []string{"text", "number", "date", "select", "user"}
+
+func validCustomFieldType(t string) bool {
+	for _, ct := range customFieldTypes {
+		if ct == t {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFieldOptions reads the choices of a select field, one per line.
+func parseFieldOptions(s string) []string {
+	var options []string
+	seen := map[string]bool{}
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || seen[strings.ToLower(line)] {
+			continue
+		}
+		seen[strings.ToLower(line)] = true
+		options = append(options, line)
+	}
+	return options
+}
+
+// projectCustomFields lists the custom fields of a project in creation
+// order, with how many issues have a value for each.
+func projectCustomFields(projectID int64) []CustomField {
+	rows, err := db.Query(`
+		SELECT f.id, f.project_id, f.name, f.type, f.options, COUNT(v.issue_id)
+		FROM custom_fields f
+		LEFT JOIN issue_field_values v ON v.field_id = f.id
+		WHERE f.project_id = ?
+		GROUP BY f.id
+		ORDER BY f.id`, projectID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var fields []CustomField
+	for rows.Next() {
+		var f CustomField
+		var options string
+		rows.Scan(&f.ID, &f.ProjectID, &f.Name, &f.Type, &options, &f.Issues)
+		f.Options = parseFieldOptions(options)
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// projectCustomField loads one custom field of the project, or nil.
+func projectCustomField(projectID, id int64) *CustomField {
+	for _, f := range projectCustomFields(projectID) {
+		if f.ID == id {
+			return &f
+		}
+	}
+	return nil
+}
+
+// attachFieldValues fills in the custom field values of issues, which all
+// belong to one project.
+func attachFieldValues(issues []Issue) {
+	if len(issues) == 0 {
+		return
+	}
+	rows, err := db.Query(`
+		SELECT v.issue_id, v.field_id, v.value, COALESCE(u.name, v.value)
+		FROM issue_field_values v
+		JOIN custom_fields f ON f.id = v.field_id
+		LEFT JOIN users u ON f.type = 'user' AND u.id = v.value
+		WHERE f.project_id = ?`, issues[0].ProjectID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	byIssue := map[int64]map[int64]FieldValue{}
+	for rows.Next() {
+		var issueID, fieldID int64
+		var v FieldValue
+		rows.Scan(&issueID, &fieldID, &v.Value, &v.Display)
+		if byIssue[issueID] == nil {
+			byIssue[issueID] = map[int64]FieldValue{}
+		}
+		byIssue[issueID][fieldID] = v
+	}
+	for i := range issues {
+		issues[i].Fields = byIssue[issues[i].ID]
+	}
+}
+
+// normalizeFieldValue checks value against the type of f and returns it in
+// its stored form. An empty value clears the field. User fields take a
+// member's id or email.
+func normalizeFieldValue(f *CustomField, value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", true
+	}
+	switch f.Type {
+	case "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return "", false
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64), true
+	case "date":
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return "", false
+		}
+	case "select":
+		for _, o := range f.Options {
+			if strings.EqualFold(o, value) {
+				return o, true
+			}
+		}
+		return "", false
+	case "user":
+		var id int64
+		err := db.QueryRow(`SELECT u.id FROM project_members pm JOIN users u ON u.id = pm.user_id
+			WHERE pm.project_id = ? AND (CAST(u.id AS TEXT) = ? OR u.email = ? COLLATE NOCASE)`,
+			f.ProjectID, value, value).Scan(&id)
+		if err != nil {
+			return "", false
+		}
+		return strconv.FormatInt(id, 10), true
+	}
+	return value, true
+}
+
+// setIssueFieldValue stores a normalized value; empty removes it.
+func setIssueFieldValue(issueID, fieldID int64, value string) {
+	if value == "" {
+		db.Exec("DELETE FROM issue_field_values WHERE issue_id = ? AND field_id = ?", issueID, fieldID)
+		return
+	}
+	db.Exec(`INSERT INTO issue_field_values (issue_id, field_id, value) VALUES (?, ?, ?)
+		ON CONFLICT (issue_id, field_id) DO UPDATE SET value = excluded.value`, issueID, fieldID, value)
+}
+
+// issueFieldDisplay is the value of a custom field as recorded in the issue
+// history: user fields by name, so the entry still reads well if the user
+// leaves; nil for no value.
+func issueFieldDisplay(issueID int64, f *CustomField) *string {
+	var v *string
+	err := db.QueryRow(`SELECT COALESCE(u.name, v.value) FROM issue_field_values v
+		LEFT JOIN users u ON ? = 'user' AND u.id = v.value
+		WHERE v.issue_id = ? AND v.field_id = ?`, f.Type, issueID, f.ID).Scan(&v)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	return v
+}
+
+// fieldValuesByName resolves the custom fields given by name, ignoring case,
+// for the API. It returns the name of the first field that doesn't exist or
+// whose value is invalid.
+func fieldValuesByName(projectID int64, values map[string]string) (map[int64]string, string) {
+	fields := projectCustomFields(projectID)
+	out := map[int64]string{}
+	for name, value := range values {
+		var field *CustomField
+		for i := range fields {
+			if strings.EqualFold(fields[i].Name, strings.TrimSpace(name)) {
+				field = &fields[i]
+			}
+		}
+		if field == nil {
+			return nil, name
+		}
+		v, ok := normalizeFieldValue(field, value)
+		if !ok {
+			return nil, name
+		}
+		out[field.ID] = v
+	}
+	return out, ""
+}
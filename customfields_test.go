@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeFieldValue(t *testing.T) {
+	openTestDB(t)
+	seedProject(t)
+	number := &CustomField{ProjectID: 1, Type: "number"}
+	date := &CustomField{ProjectID: 1, Type: "date"}
+	choice := &CustomField{ProjectID: 1, Type: "select", Options: []string{"Alta", "Baja"}}
+	user := &CustomField{ProjectID: 1, Type: "user"}
+	tests := []struct {
+		f     *CustomField
+		value string
+		want  string
+		ok    bool
+	}{
+		{number, " 1.50 ", "1.5", true},
+		{number, "1e3", "1000", true},
+		{number, "NaN", "", false},
+		{number, "Inf", "", false},
+		{number, "-infinity", "", false},
+		{number, "1e400", "", false},
+		{number, "uno", "", false},
+		{date, "2026-02-28", "2026-02-28", true},
+		{date, "2026-02-30", "", false},
+		{choice, "alta", "Alta", true},
+		{choice, "Media", "", false},
+		{user, "MEMBER@example.com", "2", true},
+		{user, "2", "2", true},
+		{user, "new@example.com", "", false},
+		{user, "5", "", false},
+		{number, "  ", "", true},
+	}
+	for _, tt := range tests {
+		got, ok := normalizeFieldValue(tt.f, tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s %q = %q, %v; want %q, %v", tt.f.Type, tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCSVRow(t *testing.T) {
+	got := csvRow([]string{"=HYPERLINK(\"http://x\")", "+1", "-1", "@SUM(A1)", "\tx", "\rx", "a=b", "", "ACME-1"})
+	want := []string{"'=HYPERLINK(\"http://x\")", "'+1", "'-1", "'@SUM(A1)", "'\tx", "'\rx", "a=b", "", "ACME-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("csvRow = %q, want %q", got, want)
+	}
+}
+
+func TestExportIssuesEscapesFormulas(t *testing.T) {
+	openTestDB(t)
+	users := seedProject(t)
+	seedProjectStatuses(1)
+	mustExec(t,
+		"INSERT INTO custom_fields (id, project_id, name, type) VALUES (1, 1, '=Ref', 'text')",
+		"INSERT INTO issues (id, project_id, number, title, status, priority) VALUES (1, 1, 1, '=cmd|'' /C calc''!A0', 'todo', 'medium')",
+		"INSERT INTO issue_field_values (issue_id, field_id, value) VALUES (1, 1, '@evil')",
+	)
+	req := httptest.NewRequest("GET", "/projects/acme/issues/export", nil)
+	req.SetPathValue("slug", "acme")
+	rec := httptest.NewRecorder()
+	handleExportIssues(rec, asUser(req, users["member"]))
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil || len(records) != 2 {
+		t.Fatalf("export: %d records, err %v", len(records), err)
+	}
+	if h := records[0]; h[len(h)-1] != "'=Ref" {
+		t.Errorf("field header %q", h[len(h)-1])
+	}
+	row := records[1]
+	if row[0] != "ACME-1" || !strings.HasPrefix(row[1], "'=") || row[len(row)-1] != "'@evil" {
+		t.Errorf("row %q", row)
+	}
+}
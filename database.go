@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -52,7 +54,7 @@ func migrate() {
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
 			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-			role TEXT NOT NULL DEFAULT 'member' CHECK(role IN ('owner','member','client')),
+			role TEXT NOT NULL DEFAULT 'member',
 			UNIQUE(project_id, user_id)
 		)`,
 		`CREATE TABLE IF NOT EXISTS milestones (
@@ -69,7 +71,7 @@ func migrate() {
 			project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
 			title TEXT NOT NULL,
 			description TEXT NOT NULL DEFAULT '',
-			status TEXT NOT NULL DEFAULT 'backlog' CHECK(status IN ('backlog','todo','in_progress','review','done')),
+			status TEXT NOT NULL DEFAULT 'backlog',
 			priority TEXT NOT NULL DEFAULT 'medium' CHECK(priority IN ('low','medium','high','urgent')),
 			assignee_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
 			due_date DATE,
@@ -125,4 +127,442 @@ func migrate() {
 	// Project content fields for API-pushed content
 	db.Exec("ALTER TABLE projects ADD COLUMN status_md TEXT NOT NULL DEFAULT ''")
 	db.Exec("ALTER TABLE projects ADD COLUMN roadmap_md TEXT NOT NULL DEFAULT ''")
+
+	// One-time numeric codes sent alongside magic links
+	db.Exec("ALTER TABLE magic_tokens ADD COLUMN code TEXT NOT NULL DEFAULT ''")
+	db.Exec("ALTER TABLE magic_tokens ADD COLUMN code_attempts INTEGER NOT NULL DEFAULT 0")
+
+	// Passkeys (WebAuthn credentials) and in-flight WebAuthn ceremonies
+	db.Exec(`CREATE TABLE IF NOT EXISTS passkeys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		credential_id BLOB UNIQUE NOT NULL,
+		name TEXT NOT NULL DEFAULT '',
+		credential TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used_at DATETIME
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS webauthn_sessions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		token TEXT UNIQUE NOT NULL,
+		user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+		data TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+
+	// TOTP second factor
+	db.Exec("ALTER TABLE users ADD COLUMN totp_secret TEXT NOT NULL DEFAULT ''")
+	db.Exec("ALTER TABLE users ADD COLUMN totp_enabled INTEGER NOT NULL DEFAULT 0")
+	db.Exec("ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0")
+	db.Exec("ALTER TABLE magic_tokens ADD COLUMN mfa_verified_at DATETIME")
+	db.Exec("ALTER TABLE magic_tokens ADD COLUMN mfa_attempts INTEGER NOT NULL DEFAULT 0")
+	db.Exec(`CREATE TABLE IF NOT EXISTS recovery_codes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		code_hash TEXT NOT NULL,
+		used_at DATETIME
+	)`)
+
+	// Per-session CSRF tokens
+	db.Exec("ALTER TABLE sessions ADD COLUMN csrf_token TEXT NOT NULL DEFAULT ''")
+
+	// Account status and login tracking for the admin console
+	db.Exec("ALTER TABLE users ADD COLUMN deactivated_at DATETIME")
+	db.Exec("ALTER TABLE users ADD COLUMN last_login_at DATETIME")
+
+	// Track who created each API key so it can be revoked with their account
+	db.Exec("ALTER TABLE api_keys ADD COLUMN created_by INTEGER REFERENCES users(id) ON DELETE SET NULL")
+
+	// Pending project invitations; membership is created on acceptance
+	db.Exec(`CREATE TABLE IF NOT EXISTS invitations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+		email TEXT NOT NULL,
+		role TEXT NOT NULL DEFAULT 'client',
+		token TEXT UNIQUE NOT NULL,
+		invited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		expires_at DATETIME NOT NULL,
+		accepted_at DATETIME,
+		declined_at DATETIME
+	)`)
+
+	// Profile and preferences
+	db.Exec("ALTER TABLE users ADD COLUMN avatar_path TEXT NOT NULL DEFAULT ''")
+	db.Exec("ALTER TABLE users ADD COLUMN language TEXT NOT NULL DEFAULT 'es'")
+	db.Exec("ALTER TABLE users ADD COLUMN timezone TEXT NOT NULL DEFAULT ''")
+	db.Exec("ALTER TABLE users ADD COLUMN notify_assigned INTEGER NOT NULL DEFAULT 1")
+	db.Exec("ALTER TABLE users ADD COLUMN notify_files INTEGER NOT NULL DEFAULT 0")
+
+	// Admin impersonation ("view as") and the audit trail it writes to
+	db.Exec("ALTER TABLE sessions ADD COLUMN impersonating_id INTEGER REFERENCES users(id) ON DELETE SET NULL")
+	db.Exec(`CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		impersonator_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		action TEXT NOT NULL,
+		target TEXT NOT NULL DEFAULT '',
+		ip TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+
+	// Personal access tokens authenticate API calls as their owner. Only a
+	// hash is stored; the prefix helps users recognise them.
+	db.Exec(`CREATE TABLE IF NOT EXISTS personal_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		token_hash TEXT UNIQUE NOT NULL,
+		prefix TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used_at DATETIME
+	)`)
+
+	// Email-domain provisioning rules and the sign-ups waiting on approval
+	db.Exec(`CREATE TABLE IF NOT EXISTS domain_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		domain TEXT NOT NULL,
+		project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+		role TEXT NOT NULL DEFAULT 'client',
+		require_approval INTEGER NOT NULL DEFAULT 0,
+		created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(domain, project_id)
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS signup_requests (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email TEXT UNIQUE NOT NULL,
+		name TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		rejected_at DATETIME
+	)`)
+
+	// SCIM provisioning: directory ids, groups mapped to project roles, and
+	// which memberships SCIM manages so manual ones are left alone
+	db.Exec("ALTER TABLE users ADD COLUMN scim_external_id TEXT NOT NULL DEFAULT ''")
+	db.Exec("ALTER TABLE project_members ADD COLUMN scim_managed INTEGER NOT NULL DEFAULT 0")
+	db.Exec(`CREATE TABLE IF NOT EXISTS scim_groups (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		display_name TEXT NOT NULL,
+		external_id TEXT NOT NULL DEFAULT '',
+		project_id INTEGER REFERENCES projects(id) ON DELETE SET NULL,
+		role TEXT NOT NULL DEFAULT 'client',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS scim_group_members (
+		group_id INTEGER NOT NULL REFERENCES scim_groups(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		PRIMARY KEY (group_id, user_id)
+	)`)
+
+	// Audit log: who did what from where, with before/after values. Entries
+	// are append-only; the triggers reject edits and deletes, except the
+	// foreign keys nulling user_id or impersonator_id when a user is deleted.
+	// The actor email stays as text.
+	db.Exec("ALTER TABLE audit_log ADD COLUMN api_key_id INTEGER")
+	db.Exec("ALTER TABLE audit_log ADD COLUMN actor TEXT NOT NULL DEFAULT ''")
+	db.Exec("ALTER TABLE audit_log ADD COLUMN via TEXT NOT NULL DEFAULT ''")
+	db.Exec("ALTER TABLE audit_log ADD COLUMN project_id INTEGER")
+	db.Exec("ALTER TABLE audit_log ADD COLUMN before_value TEXT")
+	db.Exec("ALTER TABLE audit_log ADD COLUMN after_value TEXT")
+	db.Exec("UPDATE audit_log SET actor = COALESCE((SELECT email FROM users WHERE id = audit_log.user_id), '') WHERE actor = '' AND user_id IS NOT NULL")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_audit_log_project ON audit_log(project_id, id)")
+	db.Exec("DROP TRIGGER IF EXISTS audit_log_no_update")
+	db.Exec(`CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+		WHEN NOT (new.id = old.id AND new.action IS old.action AND new.target IS old.target
+			AND new.ip IS old.ip AND new.created_at IS old.created_at AND new.api_key_id IS old.api_key_id
+			AND new.actor IS old.actor AND new.via IS old.via AND new.project_id IS old.project_id
+			AND new.before_value IS old.before_value AND new.after_value IS old.after_value
+			AND (new.user_id IS old.user_id OR new.user_id IS NULL)
+			AND (new.impersonator_id IS old.impersonator_id OR new.impersonator_id IS NULL))
+		BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END`)
+	db.Exec(`CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END`)
+
+	// Custom project roles with explicit permissions. Member roles are no
+	// longer a fixed list, so older databases lose the CHECK constraint.
+	db.Exec(`CREATE TABLE IF NOT EXISTS project_roles (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		permissions TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(project_id, name)
+	)`)
+	var membersSQL string
+	db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'project_members'").Scan(&membersSQL)
+	if strings.Contains(membersSQL, "CHECK(role IN") {
+		rebuildMembersTable()
+	}
+
+	// Threaded comments on issues. Deleted comments keep their row so replies
+	// stay attached.
+	db.Exec(`CREATE TABLE IF NOT EXISTS issue_comments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		issue_id INTEGER NOT NULL REFERENCES issues(id) ON DELETE CASCADE,
+		parent_id INTEGER REFERENCES issue_comments(id) ON DELETE CASCADE,
+		user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		body TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		deleted_at DATETIME
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_issue_comments_issue ON issue_comments(issue_id, id)")
+
+	// Field change history of issues, for the timeline and for reports such
+	// as cycle time. Values are stored as text.
+	db.Exec(`CREATE TABLE IF NOT EXISTS issue_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		issue_id INTEGER NOT NULL REFERENCES issues(id) ON DELETE CASCADE,
+		project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+		user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		actor TEXT NOT NULL DEFAULT '',
+		field TEXT NOT NULL,
+		old_value TEXT,
+		new_value TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_issue_events_issue ON issue_events(issue_id, id)")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_issue_events_project ON issue_events(project_id, field, created_at)")
+
+	// Per-project labels, many-to-many with issues
+	db.Exec(`CREATE TABLE IF NOT EXISTS labels (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+		name TEXT NOT NULL COLLATE NOCASE,
+		color TEXT NOT NULL DEFAULT '#6b7280',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(project_id, name)
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS issue_labels (
+		issue_id INTEGER NOT NULL REFERENCES issues(id) ON DELETE CASCADE,
+		label_id INTEGER NOT NULL REFERENCES labels(id) ON DELETE CASCADE,
+		PRIMARY KEY (issue_id, label_id)
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_issue_labels_label ON issue_labels(label_id)")
+
+	// Typed relations between issues of a project. blocked-by and
+	// duplicated-by are the same rows read from the target side.
+	db.Exec(`CREATE TABLE IF NOT EXISTS issue_relations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+		source_id INTEGER NOT NULL REFERENCES issues(id) ON DELETE CASCADE,
+		target_id INTEGER NOT NULL REFERENCES issues(id) ON DELETE CASCADE,
+		type TEXT NOT NULL CHECK(type IN ('blocks', 'relates', 'duplicates')),
+		created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(source_id, target_id, type)
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_issue_relations_target ON issue_relations(target_id)")
+
+	// Checklist items inside an issue. Their completion counts towards the
+	// progress of the issue's milestone.
+	db.Exec(`CREATE TABLE IF NOT EXISTS issue_checklist_items (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		issue_id INTEGER NOT NULL REFERENCES issues(id) ON DELETE CASCADE,
+		body TEXT NOT NULL,
+		done INTEGER NOT NULL DEFAULT 0,
+		position INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_issue_checklist_items_issue ON issue_checklist_items(issue_id, position)")
+
+	// Per-project workflow statuses; issues.status holds the key. Projects
+	// get the former fixed list, and older databases lose the CHECK
+	// constraint on issues.status.
+	db.Exec(`CREATE TABLE IF NOT EXISTS project_statuses (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+		key TEXT NOT NULL,
+		name TEXT NOT NULL,
+		category TEXT NOT NULL DEFAULT 'open' CHECK(category IN ('open', 'active', 'done')),
+		position INTEGER NOT NULL DEFAULT 0,
+		UNIQUE(project_id, key)
+	)`)
+	if rows, err := db.Query("SELECT id FROM projects WHERE id NOT IN (SELECT project_id FROM project_statuses)"); err == nil {
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			rows.Scan(&id)
+			ids = append(ids, id)
+		}
+		rows.Close()
+		for _, id := range ids {
+			seedProjectStatuses(id)
+		}
+	}
+	var issuesSQL string
+	db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'issues'").Scan(&issuesSQL)
+	if strings.Contains(issuesSQL, "CHECK(status IN") {
+		rebuildIssuesTable()
+	}
+
+	// Custom fields: project-defined issue fields with one text value per issue
+	db.Exec(`CREATE TABLE IF NOT EXISTS custom_fields (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+		name TEXT NOT NULL COLLATE NOCASE,
+		type TEXT NOT NULL CHECK(type IN ('text', 'number', 'date', 'select', 'user')),
+		options TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(project_id, name)
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS issue_field_values (
+		issue_id INTEGER NOT NULL REFERENCES issues(id) ON DELETE CASCADE,
+		field_id INTEGER NOT NULL REFERENCES custom_fields(id) ON DELETE CASCADE,
+		value TEXT NOT NULL,
+		PRIMARY KEY (issue_id, field_id)
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_issue_field_values_field ON issue_field_values(field_id)")
+
+	// Issue keys: a prefix per project and a per-project issue number, so
+	// issues read like ACME-42. Existing issues are numbered in creation order.
+	db.Exec("ALTER TABLE projects ADD COLUMN issue_prefix TEXT NOT NULL DEFAULT ''")
+	db.Exec("ALTER TABLE projects ADD COLUMN issue_seq INTEGER NOT NULL DEFAULT 0")
+	db.Exec("ALTER TABLE issues ADD COLUMN number INTEGER")
+	if rows, err := db.Query("SELECT id, slug FROM projects WHERE issue_prefix = '' ORDER BY id"); err == nil {
+		slugs := map[int64]string{}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			var slug string
+			rows.Scan(&id, &slug)
+			slugs[id] = slug
+			ids = append(ids, id)
+		}
+		rows.Close()
+		for _, id := range ids {
+			db.Exec("UPDATE projects SET issue_prefix = ? WHERE id = ?", uniqueIssuePrefix(slugs[id]), id)
+		}
+	}
+	db.Exec(`UPDATE issues SET number = (SELECT COUNT(*) FROM issues o WHERE o.project_id = issues.project_id AND o.id <= issues.id)
+		WHERE number IS NULL`)
+	db.Exec(`UPDATE projects SET issue_seq = MAX(issue_seq, (SELECT COALESCE(MAX(number), 0) FROM issues WHERE project_id = projects.id))`)
+	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_issues_number ON issues(project_id, number)")
+	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_projects_issue_prefix ON projects(issue_prefix) WHERE issue_prefix != ''")
+
+	// Login code attempts are also limited per address
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_magic_tokens_email ON magic_tokens(email, created_at)")
+
+	// An approved token starts the session of the browser that asked for it
+	// once
+	db.Exec("ALTER TABLE magic_tokens ADD COLUMN consumed_at DATETIME")
+
+	// Full-text search over issues, chat messages, file names and the status
+	// and roadmap documents, kept in sync by triggers. FTS5 needs the
+	// sqlite_fts5 build tag; without it search is turned off and the triggers
+	// dropped so writes keep working. The index is rebuilt whenever the
+	// triggers were missing.
+	var synced bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'trigger' AND name = 'search_issues_ai')").Scan(&synced)
+	db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(
+		title, body, kind UNINDEXED, project_id UNINDEXED, ref UNINDEXED,
+		tokenize = 'unicode61 remove_diacritics 2')`)
+	if _, err := db.Exec("SELECT COUNT(*) FROM search_index"); err != nil {
+		log.Printf("search disabled, build with -tags sqlite_fts5: %v", err)
+		for _, name := range searchTriggerNames {
+			db.Exec("DROP TRIGGER IF EXISTS " + name)
+		}
+	} else {
+		searchEnabled = true
+		for _, stmt := range searchTriggers {
+			db.Exec(stmt)
+		}
+		if !synced {
+			for _, stmt := range searchReindex {
+				db.Exec(stmt)
+			}
+		}
+	}
+
+	// SCIM may only change the accounts it created. Those provisioned before
+	// this column existed are found in the audit log.
+	if _, err := db.Exec("ALTER TABLE users ADD COLUMN scim_provisioned INTEGER NOT NULL DEFAULT 0"); err == nil {
+		db.Exec(`UPDATE users SET scim_provisioned = 1
+			WHERE email IN (SELECT target FROM audit_log WHERE action = 'scim.user.create')`)
+	}
+}
+
+// rebuildMembersTable recreates project_members without the role CHECK
+// constraint. Any failure rolls back before the old table is dropped.
+func rebuildMembersTable() {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+	stmts := []string{
+		`CREATE TABLE project_members_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			role TEXT NOT NULL DEFAULT 'member',
+			scim_managed INTEGER NOT NULL DEFAULT 0,
+			UNIQUE(project_id, user_id)
+		)`,
+		`INSERT INTO project_members_new (id, project_id, user_id, role, scim_managed)
+			SELECT id, project_id, user_id, role, scim_managed FROM project_members`,
+		"DROP TABLE project_members",
+		"ALTER TABLE project_members_new RENAME TO project_members",
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// rebuildIssuesTable recreates issues without the status CHECK constraint.
+// Many tables reference issues with ON DELETE CASCADE, so foreign keys are
+// turned off on a dedicated connection while the old table is dropped.
+func rebuildIssuesTable() {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		log.Fatal(err)
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+	const columns = `id, project_id, title, description, status, priority, assignee_id, due_date,
+		milestone_id, position, created_by, created_at, updated_at`
+	stmts := []string{
+		`CREATE TABLE issues_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+			title TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'backlog',
+			priority TEXT NOT NULL DEFAULT 'medium' CHECK(priority IN ('low','medium','high','urgent')),
+			assignee_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			due_date DATE,
+			milestone_id INTEGER REFERENCES milestones(id) ON DELETE SET NULL,
+			position INTEGER NOT NULL DEFAULT 0,
+			created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		"INSERT INTO issues_new (" + columns + ") SELECT " + columns + " FROM issues",
+		"DROP TABLE issues",
+		"ALTER TABLE issues_new RENAME TO issues",
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
 }
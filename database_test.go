@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openTestDB points the package at a fresh, migrated database in a
+// temporary directory.
+func openTestDB(t *testing.T) {
+	t.Helper()
+	initDB(filepath.Join(t.TempDir(), "portal.db"))
+	t.Cleanup(func() { db.Close() })
+}
+
+// mustExec runs statements that a test can't continue without.
+func mustExec(t *testing.T, stmts ...string) {
+	t.Helper()
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+}
+
+// withoutForeignKeys runs stmts in one transaction on a connection with
+// foreign keys off, the way an older schema is put back in place.
+func withoutForeignKeys(t *testing.T, stmts ...string) {
+	t.Helper()
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF")
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tableSQL(t *testing.T, name string) string {
+	t.Helper()
+	var s string
+	if err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&s); err != nil {
+		t.Fatalf("table %s: %v", name, err)
+	}
+	return s
+}
+
+func checkForeignKeys(t *testing.T) {
+	t.Helper()
+	rows, err := db.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if rows.Next() {
+		t.Error("foreign_key_check reports dangling references")
+	}
+}
+
+func TestMigrateIsRepeatable(t *testing.T) {
+	openTestDB(t)
+	members, issues := tableSQL(t, "project_members"), tableSQL(t, "issues")
+	migrate()
+	if tableSQL(t, "project_members") != members || tableSQL(t, "issues") != issues {
+		t.Error("a second migrate rebuilt tables that were already current")
+	}
+}
+
+func TestMigrateDropsMemberRoleCheck(t *testing.T) {
+	openTestDB(t)
+	mustExec(t,
+		"INSERT INTO users (id, email, name) VALUES (1, 'ana@example.com', 'Ana'), (2, 'bea@example.com', 'Bea')",
+		"INSERT INTO projects (id, name, slug) VALUES (1, 'Acme', 'acme')",
+	)
+	// project_members as it was before custom roles.
+	withoutForeignKeys(t,
+		"DROP TABLE project_members",
+		`CREATE TABLE project_members (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			role TEXT NOT NULL DEFAULT 'member' CHECK(role IN ('owner','member','client')),
+			scim_managed INTEGER NOT NULL DEFAULT 0,
+			UNIQUE(project_id, user_id)
+		)`,
+		"INSERT INTO project_members (id, project_id, user_id, role, scim_managed) VALUES (7, 1, 1, 'owner', 1)",
+	)
+
+	migrate()
+
+	if strings.Contains(tableSQL(t, "project_members"), "CHECK(role IN") {
+		t.Fatal("project_members still has the role CHECK")
+	}
+	var role string
+	var scimManaged bool
+	err := db.QueryRow("SELECT role, scim_managed FROM project_members WHERE id = 7 AND project_id = 1 AND user_id = 1").
+		Scan(&role, &scimManaged)
+	if err != nil || role != "owner" || !scimManaged {
+		t.Errorf("member not kept: role %q, scim_managed %v, err %v", role, scimManaged, err)
+	}
+	if _, err := db.Exec("INSERT INTO project_members (project_id, user_id, role) VALUES (1, 2, 'Diseño')"); err != nil {
+		t.Errorf("custom role rejected: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO project_members (project_id, user_id, role) VALUES (1, 2, 'member')"); err == nil {
+		t.Error("UNIQUE(project_id, user_id) lost in the rebuild")
+	}
+	checkForeignKeys(t)
+}
+
+func TestMigrateDropsIssueStatusCheck(t *testing.T) {
+	openTestDB(t)
+	mustExec(t,
+		"INSERT INTO users (id, email, name) VALUES (1, 'ana@example.com', 'Ana')",
+		"INSERT INTO projects (id, name, slug) VALUES (1, 'Acme', 'acme')",
+		"INSERT INTO labels (id, project_id, name) VALUES (1, 1, 'bug')",
+	)
+	// issues as it was before custom workflows, with rows in tables that
+	// cascade from it.
+	withoutForeignKeys(t,
+		"DROP TABLE issues",
+		`CREATE TABLE issues (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+			title TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'backlog' CHECK(status IN ('backlog','todo','in_progress','review','done')),
+			priority TEXT NOT NULL DEFAULT 'medium' CHECK(priority IN ('low','medium','high','urgent')),
+			assignee_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			due_date DATE,
+			milestone_id INTEGER REFERENCES milestones(id) ON DELETE SET NULL,
+			position INTEGER NOT NULL DEFAULT 0,
+			created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`INSERT INTO issues (id, project_id, title, status, assignee_id, created_by)
+			VALUES (40, 1, 'Logo', 'done', 1, 1), (42, 1, 'Login', 'review', 1, 1)`,
+		"INSERT INTO issue_comments (issue_id, user_id, body) VALUES (42, 1, 'Hecho')",
+		"INSERT INTO issue_labels (issue_id, label_id) VALUES (42, 1)",
+		"INSERT INTO issue_relations (project_id, source_id, target_id, type) VALUES (1, 40, 42, 'blocks')",
+	)
+
+	migrate()
+
+	if strings.Contains(tableSQL(t, "issues"), "CHECK(status IN") {
+		t.Fatal("issues still has the status CHECK")
+	}
+	var title, status string
+	var number int
+	err := db.QueryRow("SELECT title, status, number FROM issues WHERE id = 42 AND project_id = 1 AND assignee_id = 1").
+		Scan(&title, &status, &number)
+	if err != nil || title != "Login" || status != "review" || number != 2 {
+		t.Errorf("issue not kept: %q %q #%d, err %v", title, status, number, err)
+	}
+	for _, table := range []string{"issue_comments", "issue_labels", "issue_relations"} {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n)
+		if n != 1 {
+			t.Errorf("%s has %d rows after the rebuild, want 1", table, n)
+		}
+	}
+	if _, err := db.Exec("UPDATE issues SET status = 'qa' WHERE id = 42"); err != nil {
+		t.Errorf("custom status rejected: %v", err)
+	}
+	if _, err := db.Exec("UPDATE issues SET priority = 'someday' WHERE id = 42"); err == nil {
+		t.Error("priority CHECK lost in the rebuild")
+	}
+	checkForeignKeys(t)
+
+	// Foreign keys are back on: deleting the issue cascades.
+	mustExec(t, "DELETE FROM issues WHERE id = 42")
+	var comments int
+	db.QueryRow("SELECT COUNT(*) FROM issue_comments").Scan(&comments)
+	if comments != 0 {
+		t.Error("comments outlived their issue; foreign keys are off")
+	}
+}
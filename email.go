@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+)
+
+// sendEmail delivers an HTML email through the Resend API.
+func sendEmail(to, subject, htmlBody string) {
+	payload, _ := json.Marshal(map[string]any{
+		"from":    "Portal <portal@mentasystems.com>",
+		"to":      []string{to},
+		"subject": subject,
+		"html":    htmlBody,
+	})
+
+	req, _ := http.NewRequest("POST", "https://api.resend.com/emails", bytes.NewReader(payload))
+	req.Header.Set("Authorization", "Bearer "+cfg.ResendAPIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Failed to send email to %s: %v", to, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var resErr map[string]any
+		json.NewDecoder(resp.Body).Decode(&resErr)
+		log.Printf("Resend API error (%d) sending to %s: %v", resp.StatusCode, to, resErr)
+	}
+}
+
+func sendInviteEmail(to, projectName, inviterName, link string) {
+	if cfg.ResendAPIKey == "" {
+		log.Printf("RESEND_API_KEY not configured, invitation for %s to %s: %s", to, projectName, link)
+		return
+	}
+
+	htmlBody := fmt.Sprintf(`<div style="font-family:sans-serif;max-width:480px;margin:0 auto;padding:24px">
+<h2 style="color:#333">Invitación a %s</h2>
+<p><strong>%s</strong> te ha invitado a unirte al proyecto <strong>%s</strong> en Portal.</p>
+<a href="%s" style="display:inline-block;background:#2563eb;color:#fff;padding:12px 24px;border-radius:6px;text-decoration:none;font-weight:bold">Ver invitación</a>
+<p style="margin-top:24px;color:#666;font-size:14px">La invitación caduca en 7 días.</p>
+<p style="color:#999;font-size:12px">Si no esperabas esta invitación, puedes rechazarla o ignorar este mensaje.</p>
+</div>`, html.EscapeString(projectName), html.EscapeString(inviterName), html.EscapeString(projectName), link)
+
+	sendEmail(to, fmt.Sprintf("%s te invita a %s", inviterName, projectName), htmlBody)
+}
+
+func sendSignupApprovedEmail(to string) {
+	link := cfg.BaseURL + "/login"
+	if cfg.ResendAPIKey == "" {
+		log.Printf("RESEND_API_KEY not configured, sign-up approved for %s: %s", to, link)
+		return
+	}
+	htmlBody := fmt.Sprintf(`<div style="font-family:sans-serif;max-width:480px;margin:0 auto;padding:24px">
+<h2 style="color:#333">Acceso aprobado</h2>
+<p>Tu cuenta <strong>%s</strong> ya tiene acceso al portal.</p>
+<a href="%s" style="display:inline-block;background:#2563eb;color:#fff;padding:12px 24px;border-radius:6px;text-decoration:none;font-weight:bold">Iniciar sesión</a>
+</div>`, html.EscapeString(to), link)
+	sendEmail(to, "Tu acceso al portal ha sido aprobado", htmlBody)
+}
+
+// notifyAssigned emails the new assignee of an issue unless they assigned it
+// to themselves or turned the notification off.
+func notifyAssigned(p *Project, actor *User, assigneeID *int64, title string) {
+	if assigneeID == nil || *assigneeID == actor.ID {
+		return
+	}
+	var email string
+	var enabled bool
+	err := db.QueryRow("SELECT email, notify_assigned FROM users WHERE id = ? AND deactivated_at IS NULL", *assigneeID).
+		Scan(&email, &enabled)
+	if err != nil || !enabled {
+		return
+	}
+	link := cfg.BaseURL + "/projects/" + p.Slug + "?tab=issues"
+	if cfg.ResendAPIKey == "" {
+		log.Printf("RESEND_API_KEY not configured, assignment notice for %s: %s (%s)", email, title, link)
+		return
+	}
+	htmlBody := fmt.Sprintf(`<div style="font-family:sans-serif;max-width:480px;margin:0 auto;padding:24px">
+<p><strong>%s</strong> te ha asignado una tarea en <strong>%s</strong>:</p>
+<p style="font-size:16px">%s</p>
+<a href="%s" style="display:inline-block;background:#2563eb;color:#fff;padding:12px 24px;border-radius:6px;text-decoration:none;font-weight:bold">Ver tareas</a>
+<p style="margin-top:24px;color:#999;font-size:12px">Puedes desactivar estos avisos en tu perfil.</p>
+</div>`, html.EscapeString(actor.Name), html.EscapeString(p.Name), html.EscapeString(title), link)
+	sendEmail(email, fmt.Sprintf("[%s] Tarea asignada: %s", p.Name, title), htmlBody)
+}
+
+// notifyFileUploaded emails project members who opted in to file notices.
+func notifyFileUploaded(p *Project, actor *User, fileName string) {
+	rows, err := db.Query(`SELECT u.email FROM project_members pm JOIN users u ON u.id = pm.user_id
+		WHERE pm.project_id = ? AND u.id != ? AND u.notify_files = 1 AND u.deactivated_at IS NULL`, p.ID, actor.ID)
+	if err != nil {
+		return
+	}
+	var emails []string
+	for rows.Next() {
+		var e string
+		rows.Scan(&e)
+		emails = append(emails, e)
+	}
+	rows.Close()
+
+	link := cfg.BaseURL + "/projects/" + p.Slug + "?tab=files"
+	htmlBody := fmt.Sprintf(`<div style="font-family:sans-serif;max-width:480px;margin:0 auto;padding:24px">
+<p><strong>%s</strong> ha subido <strong>%s</strong> a <strong>%s</strong>.</p>
+<a href="%s" style="display:inline-block;background:#2563eb;color:#fff;padding:12px 24px;border-radius:6px;text-decoration:none;font-weight:bold">Ver archivos</a>
+<p style="margin-top:24px;color:#999;font-size:12px">Puedes desactivar estos avisos en tu perfil.</p>
+</div>`, html.EscapeString(actor.Name), html.EscapeString(fileName), html.EscapeString(p.Name), link)
+	for _, e := range emails {
+		if cfg.ResendAPIKey == "" {
+			log.Printf("RESEND_API_KEY not configured, file notice for %s: %s (%s)", e, fileName, link)
+			continue
+		}
+		sendEmail(e, fmt.Sprintf("[%s] Nuevo archivo: %s", p.Name, fileName), htmlBody)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const maxAvatarSize = 2 << 20 // 2MB
+
+var languages = []string{"es", "en"}
+
+// avatarTypes maps the sniffed content type of accepted avatar images to the
+// extension they are stored with.
+var avatarTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+func accountData(u *User) map[string]any {
+	var totpEnabled bool
+	db.QueryRow("SELECT totp_enabled FROM users WHERE id = ?", u.ID).Scan(&totpEnabled)
+	return map[string]any{
+		"User":              u,
+		"Projects":          userProjects(u),
+		"Languages":         languages,
+		"Passkeys":          userPasskeys(u.ID),
+		"Tokens":            userPersonalTokens(u.ID),
+		"TOTPEnabled":       totpEnabled,
+		"TOTPEnforced":      u.Role == "admin" && cfg.RequireAdminTOTP,
+		"RecoveryRemaining": remainingRecoveryCodes(u.ID),
+	}
+}
+
+func handleAccount(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+
+	if r.Method == "POST" {
+		// Credentials and profile stay in the hands of the account owner
+		if u.Impersonator != nil {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		data := accountData(u)
+		code := strings.TrimSpace(r.FormValue("code"))
+		switch r.FormValue("action") {
+		case "profile":
+			if msg := updateProfile(u, r); msg != "" {
+				data["ProfileError"] = msg
+				renderTemplate(w, "account.html", data)
+				return
+			}
+		case "avatar":
+			if msg := saveAvatar(u, r); msg != "" {
+				data["ProfileError"] = msg
+				renderTemplate(w, "account.html", data)
+				return
+			}
+		case "remove_avatar":
+			removeAvatarFile(u.AvatarPath)
+			db.Exec("UPDATE users SET avatar_path = '' WHERE id = ?", u.ID)
+		case "create_token":
+			name := strings.TrimSpace(r.FormValue("name"))
+			if name == "" {
+				name = "Token"
+			}
+			token := generatePersonalToken()
+			db.Exec("INSERT INTO personal_tokens (user_id, name, token_hash, prefix) VALUES (?, ?, ?, ?)",
+				u.ID, name, hashToken(token), token[:len(personalTokenPrefix)+6])
+			recordAudit(r, u, "token.create", name)
+			// The full token is only shown once
+			data["Tokens"] = userPersonalTokens(u.ID)
+			data["NewToken"] = token
+			renderTemplate(w, "account.html", data)
+			return
+		case "revoke_token":
+			var name string
+			if db.QueryRow("SELECT name FROM personal_tokens WHERE id = ? AND user_id = ?", r.FormValue("token_id"), u.ID).Scan(&name) == nil {
+				db.Exec("DELETE FROM personal_tokens WHERE id = ? AND user_id = ?", r.FormValue("token_id"), u.ID)
+				recordAudit(r, u, "token.revoke", name)
+			}
+		case "delete_passkey":
+			var name string
+			if db.QueryRow("SELECT name FROM passkeys WHERE id = ? AND user_id = ?", r.FormValue("passkey_id"), u.ID).Scan(&name) == nil {
+				db.Exec("DELETE FROM passkeys WHERE id = ? AND user_id = ?", r.FormValue("passkey_id"), u.ID)
+				recordAudit(r, u, "passkey.delete", name)
+			}
+		case "totp_enable":
+			if !confirmTOTPEnrollment(u.ID, code) {
+				data["TOTPError"] = "Código incorrecto"
+				setupTOTPData(data, u)
+				renderTemplate(w, "account.html", data)
+				return
+			}
+			recordAudit(r, u, "totp.enable", "")
+			data = accountData(u)
+			data["RecoveryCodes"] = regenerateRecoveryCodes(u.ID)
+			renderTemplate(w, "account.html", data)
+			return
+		case "totp_disable":
+			if data["TOTPEnforced"].(bool) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if !verifySecondFactor(u.ID, code) {
+				data["TOTPError"] = "Código incorrecto"
+				renderTemplate(w, "account.html", data)
+				return
+			}
+			db.Exec("UPDATE users SET totp_enabled = 0, totp_secret = '', totp_last_step = 0 WHERE id = ?", u.ID)
+			db.Exec("DELETE FROM recovery_codes WHERE user_id = ?", u.ID)
+			recordAudit(r, u, "totp.disable", "")
+		case "totp_recovery":
+			if !verifySecondFactor(u.ID, code) {
+				data["TOTPError"] = "Código incorrecto"
+				renderTemplate(w, "account.html", data)
+				return
+			}
+			recordAudit(r, u, "totp.recovery_codes", "")
+			data["RecoveryCodes"] = regenerateRecoveryCodes(u.ID)
+			data["RecoveryRemaining"] = recoveryCodeCount
+			renderTemplate(w, "account.html", data)
+			return
+		}
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+
+	data := accountData(u)
+	if r.URL.Query().Get("totp") == "setup" && !data["TOTPEnabled"].(bool) {
+		setupTOTPData(data, u)
+	}
+	renderTemplate(w, "account.html", data)
+}
+
+func setupTOTPData(data map[string]any, u *User) {
+	secret := pendingTOTPSecret(u.ID)
+	data["TOTPSetup"] = true
+	data["TOTPSecret"] = formatTOTPSecret(secret)
+	data["TOTPURI"] = totpURI(u.Email, secret)
+}
+
+// updateProfile saves the profile form and returns an error message for the
+// user, or "" on success.
+func updateProfile(u *User, r *http.Request) string {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		return "El nombre no puede estar vacío"
+	}
+	lang := r.FormValue("language")
+	valid := false
+	for _, l := range languages {
+		if l == lang {
+			valid = true
+		}
+	}
+	if !valid {
+		lang = "es"
+	}
+	tz := strings.TrimSpace(r.FormValue("timezone"))
+	if tz != "" {
+		if _, err := time.LoadLocation(tz); err != nil {
+			return "Zona horaria desconocida: " + tz
+		}
+	}
+	db.Exec(`UPDATE users SET name = ?, language = ?, timezone = ?, notify_assigned = ?, notify_files = ? WHERE id = ?`,
+		name, lang, tz, r.FormValue("notify_assigned") == "1", r.FormValue("notify_files") == "1", u.ID)
+	return ""
+}
+
+// saveAvatar stores an uploaded avatar next to the project uploads and
+// replaces the previous one.
+func saveAvatar(u *User, r *http.Request) string {
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		return "Selecciona una imagen"
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxAvatarSize+1))
+	if err != nil {
+		return "No se pudo leer la imagen"
+	}
+	if len(data) > maxAvatarSize {
+		return "La imagen no puede superar 2 MB"
+	}
+	ext, ok := avatarTypes[http.DetectContentType(data)]
+	if !ok {
+		return "Formato no admitido (usa PNG, JPEG, GIF o WebP)"
+	}
+
+	dir := filepath.Join(cfg.UploadDir, "avatars")
+	os.MkdirAll(dir, 0755)
+	// A fresh name per upload also busts browser caches
+	filename := fmt.Sprintf("%d_%s%s", u.ID, generateToken()[:12], ext)
+	if err := os.WriteFile(filepath.Join(dir, filename), data, 0644); err != nil {
+		return "No se pudo guardar la imagen"
+	}
+	removeAvatarFile(u.AvatarPath)
+	db.Exec("UPDATE users SET avatar_path = ? WHERE id = ?", filename, u.ID)
+	return ""
+}
+
+func removeAvatarFile(name string) {
+	if name != "" {
+		os.Remove(filepath.Join(cfg.UploadDir, "avatars", filepath.Base(name)))
+	}
+}
+
+func handleAvatar(w http.ResponseWriter, r *http.Request) {
+	var name string
+	err := db.QueryRow("SELECT avatar_path FROM users WHERE id = ?", r.PathValue("id")).Scan(&name)
+	if err != nil || name == "" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeFile(w, r, filepath.Join(cfg.UploadDir, "avatars", filepath.Base(name)))
+}
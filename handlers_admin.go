@@ -1 +1,154 @@
 package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+func handleAdminUsers(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u.Role != "admin" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	renderTemplate(w, "admin_users.html", map[string]any{
+		"User":        u,
+		"Projects":    userProjects(u),
+		"Users":       allUsers(q),
+		"Query":       q,
+		"Error":       r.URL.Query().Get("error"),
+		"ConfigAdmin": configAdminSet(),
+		"Roles":       []string{"admin", "user", "client"},
+		"AdminPage":   "users",
+	})
+}
+
+func handleAdminUpdateUser(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u.Role != "admin" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	var email, prevRole, prevName string
+	if err := db.QueryRow("SELECT email, role, name FROM users WHERE id = ?", id).Scan(&email, &prevRole, &prevName); err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	back := url.Values{}
+	if q := r.FormValue("q"); q != "" {
+		back.Set("q", q)
+	}
+	fail := func(msg string) {
+		back.Set("error", msg)
+		http.Redirect(w, r, "/admin/users?"+back.Encode(), http.StatusSeeOther)
+	}
+
+	switch r.FormValue("action") {
+	case "set_role":
+		role := r.FormValue("role")
+		if role != "admin" && role != "user" && role != "client" {
+			http.Error(w, "Invalid role", http.StatusBadRequest)
+			return
+		}
+		if id == u.ID && role != "admin" {
+			fail("No puedes quitarte el rol de administrador")
+			return
+		}
+		if configAdminSet()[email] && role != "admin" {
+			fail("Este administrador está definido en la configuración")
+			return
+		}
+		db.Exec("UPDATE users SET role = ? WHERE id = ?", role, id)
+		recordChange(r, u, 0, "user.role", email, map[string]any{"role": prevRole}, map[string]any{"role": role})
+	case "rename":
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" {
+			fail("El nombre es obligatorio")
+			return
+		}
+		db.Exec("UPDATE users SET name = ? WHERE id = ?", name, id)
+		recordChange(r, u, 0, "user.rename", email, map[string]any{"name": prevName}, map[string]any{"name": name})
+	case "deactivate":
+		if id == u.ID {
+			fail("No puedes desactivar tu propia cuenta")
+			return
+		}
+		deactivateUser(id)
+		recordAudit(r, u, "user.deactivate", email)
+	case "reactivate":
+		db.Exec("UPDATE users SET deactivated_at = NULL WHERE id = ?", id)
+		recordAudit(r, u, "user.reactivate", email)
+	}
+	http.Redirect(w, r, "/admin/users?"+back.Encode(), http.StatusSeeOther)
+}
+
+// deactivateUser blocks the account and revokes its access immediately:
+// sessions, pending login links, personal tokens and the API keys it created.
+// Keys from before creators were recorded have none and are left alone; the
+// project settings say so. The users row is kept so issues, files and
+// messages stay attributed.
+func deactivateUser(id int64) {
+	db.Exec("UPDATE users SET deactivated_at = CURRENT_TIMESTAMP WHERE id = ? AND deactivated_at IS NULL", id)
+	db.Exec("DELETE FROM sessions WHERE user_id = ?", id)
+	db.Exec("DELETE FROM magic_tokens WHERE email = (SELECT email FROM users WHERE id = ?)", id)
+	db.Exec("DELETE FROM api_keys WHERE created_by = ?", id)
+	db.Exec("DELETE FROM personal_tokens WHERE user_id = ?", id)
+}
+
+// configAdminSet returns the admin emails from the config, which are
+// re-promoted on every start and therefore can't be demoted here.
+func configAdminSet() map[string]bool {
+	set := map[string]bool{}
+	for _, e := range cfg.AdminEmails {
+		set[strings.TrimSpace(strings.ToLower(e))] = true
+	}
+	return set
+}
+
+func allUsers(q string) []User {
+	query := `SELECT id, email, name, role, avatar_path, deactivated_at, last_login_at, created_at FROM users`
+	var args []any
+	if q != "" {
+		query += ` WHERE email LIKE ? OR name LIKE ?`
+		like := "%" + q + "%"
+		args = append(args, like, like)
+	}
+	query += ` ORDER BY name, email`
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var users []User
+	for rows.Next() {
+		var u User
+		rows.Scan(&u.ID, &u.Email, &u.Name, &u.Role, &u.AvatarPath, &u.DeactivatedAt, &u.LastLoginAt, &u.CreatedAt)
+		users = append(users, u)
+	}
+
+	// Attach project memberships
+	mrows, err := db.Query(`
+		SELECT pm.user_id, p.id, p.name, p.slug, pm.role
+		FROM project_members pm JOIN projects p ON p.id = pm.project_id
+		ORDER BY p.name`)
+	if err != nil {
+		return users
+	}
+	defer mrows.Close()
+	memberships := map[int64][]Project{}
+	for mrows.Next() {
+		var uid int64
+		var p Project
+		mrows.Scan(&uid, &p.ID, &p.Name, &p.Slug, &p.MemberRole)
+		memberships[uid] = append(memberships[uid], p)
+	}
+	for i := range users {
+		users[i].Memberships = memberships[users[i].ID]
+	}
+	return users
+}
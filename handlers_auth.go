@@ -1,46 +1,168 @@
 package main
 
 import (
-	"bytes"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"log"
+	"math/big"
 	"net/http"
 	"strings"
 	"time"
 )
 
+const (
+	magicTokenTTL   = 15 * time.Minute
+	maxCodeAttempts = 5
+	// maxEmailCodeAttempts caps the guesses against one address over the
+	// last hour, however many codes were requested for it.
+	maxEmailCodeAttempts = 10
+)
+
+const errAccountDeactivated = "Esta cuenta está desactivada"
+
 func generateToken() string {
 	b := make([]byte, 32)
 	rand.Read(b)
 	return hex.EncodeToString(b)
 }
 
+// generateLoginCode returns a random six-digit numeric code.
+func generateLoginCode() string {
+	n, _ := rand.Int(rand.Reader, big.NewInt(1000000))
+	return fmt.Sprintf("%06d", n.Int64())
+}
+
+// createMagicToken stores a new magic link token with its one-time code and
+// emails both to the user.
+func createMagicToken(email string) (token, link string) {
+	token = generateToken()
+	code := generateLoginCode()
+	db.Exec("INSERT INTO magic_tokens (email, token, code) VALUES (?, ?, ?)", email, token, code)
+	link = fmt.Sprintf("%s/auth/approve?token=%s", cfg.BaseURL, token)
+	go sendMagicEmail(email, link, code)
+	return token, link
+}
+
+func getMagicToken(token string) (*MagicToken, error) {
+	var mt MagicToken
+	err := db.QueryRow(
+		`SELECT id, email, token, code, code_attempts, created_at, approved_at, mfa_verified_at, mfa_attempts
+		FROM magic_tokens WHERE token = ?`, token,
+	).Scan(&mt.ID, &mt.Email, &mt.Token, &mt.Code, &mt.CodeAttempts, &mt.CreatedAt, &mt.ApprovedAt,
+		&mt.MFAVerifiedAt, &mt.MFAAttempts)
+	if err != nil {
+		return nil, err
+	}
+	return &mt, nil
+}
+
+func userByEmail(email string) (*User, error) {
+	var u User
+	err := db.QueryRow("SELECT id, email, name, role, totp_enabled, deactivated_at FROM users WHERE email = ?", email).
+		Scan(&u.ID, &u.Email, &u.Name, &u.Role, &u.TOTPEnabled, &u.DeactivatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func totpURL(token string) string {
+	return "/auth/totp?token=" + token
+}
+
+// consumeMagicToken marks that the token has logged in the browser it was
+// requested from, and reports whether this call did. A token yields that
+// session only once.
+func consumeMagicToken(id int64) bool {
+	return db.QueryRow("UPDATE magic_tokens SET consumed_at = CURRENT_TIMESTAMP WHERE id = ? AND consumed_at IS NULL RETURNING id", id).
+		Scan(&id) == nil
+}
+
+// beginSecondFactor holds a login that passed its first factor elsewhere, SSO
+// or a passkey, until the TOTP step, as an approved email link is. It returns
+// the URL of that step.
+func beginSecondFactor(email string) string {
+	token := generateToken()
+	db.Exec("INSERT INTO magic_tokens (email, token, approved_at) VALUES (?, ?, CURRENT_TIMESTAMP)", email, token)
+	return totpURL(token)
+}
+
+// startSession creates a new session for the user, sets the session cookie
+// and records the login.
+func startSession(w http.ResponseWriter, r *http.Request, userID int64) {
+	sessionToken := generateToken()
+	expires := time.Now().Add(30 * 24 * time.Hour)
+	db.Exec("INSERT INTO sessions (user_id, token, expires_at, csrf_token) VALUES (?, ?, ?, ?)",
+		userID, sessionToken, expires, generateToken())
+	db.Exec("UPDATE users SET last_login_at = CURRENT_TIMESTAMP WHERE id = ?", userID)
+	if u, err := loadUser(userID); err == nil {
+		recordAudit(r, u, "auth.login", r.URL.Path)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session",
+		Value:    sessionToken,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// loginData builds the template data for login.html.
+func loginData(errMsg string) map[string]any {
+	return map[string]any{"Error": errMsg, "OIDC": oidcEnabled()}
+}
+
+// signupData shows why a sign-up didn't go through, as a notice when it
+// awaits approval.
+func signupData(msg string) map[string]any {
+	data := loginData("")
+	if msg == errSignupPending {
+		data["Notice"] = msg
+	} else {
+		data["Error"] = msg
+	}
+	return data
+}
+
+// approvedUser is the account an approved link or code logs into. Addresses
+// covered by a domain rule get their account, or sign-up request, only now
+// that they are proven.
+func approvedUser(email string) (*User, string) {
+	if u, err := userByEmail(email); err == nil {
+		return u, ""
+	}
+	return provisionFromDomain(email, "")
+}
+
 func handleLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
-		renderTemplate(w, "login.html", nil)
+		renderTemplate(w, "login.html", loginData(""))
 		return
 	}
 	email := strings.TrimSpace(strings.ToLower(r.FormValue("email")))
 	if email == "" {
-		renderTemplate(w, "login.html", map[string]any{"Error": "El email es obligatorio"})
+		renderTemplate(w, "login.html", loginData("El email es obligatorio"))
 		return
 	}
 
-	var exists bool
-	db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email = ?)", email).Scan(&exists)
-	if !exists {
-		renderTemplate(w, "login.html", map[string]any{"Error": "No existe una cuenta con ese email"})
+	// Unknown addresses a domain rule covers get a link too; their account
+	// is created once they use it.
+	u, err := userByEmail(email)
+	if err != nil {
+		if msg := signupBlocked(email); msg != "" {
+			renderTemplate(w, "login.html", signupData(msg))
+			return
+		}
+	} else if u.DeactivatedAt != nil {
+		renderTemplate(w, "login.html", loginData(errAccountDeactivated))
 		return
 	}
 
-	token := generateToken()
-	db.Exec("INSERT INTO magic_tokens (email, token) VALUES (?, ?)", email, token)
-
-	link := fmt.Sprintf("%s/auth/approve?token=%s", cfg.BaseURL, token)
-	go sendMagicEmail(email, link)
+	token, link := createMagicToken(email)
 	log.Printf("Magic link for %s: %s", email, link)
 
 	renderTemplate(w, "login_sent.html", map[string]any{"Email": email, "Token": token})
@@ -53,10 +175,7 @@ func handleApprove(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var mt MagicToken
-	err := db.QueryRow(
-		"SELECT id, email, token, created_at, approved_at FROM magic_tokens WHERE token = ?", token,
-	).Scan(&mt.ID, &mt.Email, &mt.Token, &mt.CreatedAt, &mt.ApprovedAt)
+	mt, err := getMagicToken(token)
 	if err != nil {
 		renderTemplate(w, "approve.html", map[string]any{"Error": "Enlace inválido o expirado"})
 		return
@@ -65,37 +184,38 @@ func handleApprove(w http.ResponseWriter, r *http.Request) {
 		renderTemplate(w, "approve.html", map[string]any{"Error": "Este enlace ya ha sido utilizado"})
 		return
 	}
-	if time.Since(mt.CreatedAt) > 15*time.Minute {
+	if time.Since(mt.CreatedAt) > magicTokenTTL {
 		renderTemplate(w, "approve.html", map[string]any{"Error": "Este enlace ha expirado (15 min)"})
 		return
 	}
 
+	if u, err := userByEmail(mt.Email); err == nil && u.DeactivatedAt != nil {
+		renderTemplate(w, "approve.html", map[string]any{"Error": errAccountDeactivated})
+		return
+	}
+
 	if r.Method == "GET" {
 		renderTemplate(w, "approve.html", map[string]any{"Token": token, "Email": mt.Email})
 		return
 	}
 
 	db.Exec("UPDATE magic_tokens SET approved_at = CURRENT_TIMESTAMP WHERE id = ?", mt.ID)
-
-	var u User
-	err = db.QueryRow("SELECT id, email, name, role FROM users WHERE email = ?", mt.Email).Scan(&u.ID, &u.Email, &u.Name, &u.Role)
-	if err != nil {
-		http.Error(w, "Usuario no encontrado", http.StatusInternalServerError)
+	u, msg := approvedUser(mt.Email)
+	if u == nil {
+		if msg == errSignupPending {
+			renderTemplate(w, "approve.html", map[string]any{"Notice": msg})
+		} else {
+			renderTemplate(w, "approve.html", map[string]any{"Error": msg})
+		}
 		return
 	}
 
-	sessionToken := generateToken()
-	expires := time.Now().Add(30 * 24 * time.Hour)
-	db.Exec("INSERT INTO sessions (user_id, token, expires_at) VALUES (?, ?, ?)", u.ID, sessionToken, expires)
+	if totpRequired(u) {
+		http.Redirect(w, r, totpURL(token), http.StatusSeeOther)
+		return
+	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session",
-		Value:    sessionToken,
-		Path:     "/",
-		Expires:  expires,
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-	})
+	startSession(w, r, u.ID)
 
 	renderTemplate(w, "approve.html", map[string]any{"Approved": true, "Email": mt.Email})
 }
@@ -119,50 +239,202 @@ func handleAuthStatus(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	var mt MagicToken
-	err := db.QueryRow(
-		"SELECT id, email, token, created_at, approved_at FROM magic_tokens WHERE token = ?", token,
-	).Scan(&mt.ID, &mt.Email, &mt.Token, &mt.CreatedAt, &mt.ApprovedAt)
-	if err != nil || mt.ApprovedAt == nil {
+	mt, err := getMagicToken(token)
+	if err != nil || mt.ApprovedAt == nil || mt.Code == "" || time.Since(*mt.ApprovedAt) > magicTokenTTL {
 		w.Write([]byte(`{"approved":false}`))
 		return
 	}
 
-	var u User
-	err = db.QueryRow("SELECT id, email, name, role FROM users WHERE email = ?", mt.Email).Scan(&u.ID, &u.Email, &u.Name, &u.Role)
-	if err != nil {
+	u, err := userByEmail(mt.Email)
+	if err != nil || u.DeactivatedAt != nil {
+		w.Write([]byte(`{"approved":false}`))
+		return
+	}
+	// The second factor may be completed on either device
+	if totpRequired(u) && mt.MFAVerifiedAt == nil {
+		w.Write([]byte(`{"approved":false,"totp":true}`))
+		return
+	}
+	if !consumeMagicToken(mt.ID) {
 		w.Write([]byte(`{"approved":false}`))
 		return
 	}
 
-	sessionToken := generateToken()
-	expires := time.Now().Add(30 * 24 * time.Hour)
-	db.Exec("INSERT INTO sessions (user_id, token, expires_at) VALUES (?, ?, ?)", u.ID, sessionToken, expires)
-
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session",
-		Value:    sessionToken,
-		Path:     "/",
-		Expires:  expires,
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-	})
+	startSession(w, r, u.ID)
 
 	w.Write([]byte(`{"approved":true}`))
 }
 
+// handleVerifyCode lets the user type the six-digit code from the magic link
+// email instead of clicking the link. Codes share the link's expiry and allow
+// a limited number of attempts.
+func handleVerifyCode(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	code := strings.TrimSpace(r.FormValue("code"))
+
+	mt, err := getMagicToken(token)
+	if err != nil {
+		renderTemplate(w, "login.html", loginData("Código inválido o expirado"))
+		return
+	}
+	sent := map[string]any{"Email": mt.Email, "Token": mt.Token}
+	if mt.ApprovedAt != nil {
+		sent["Error"] = "Este código ya ha sido utilizado"
+		renderTemplate(w, "login_sent.html", sent)
+		return
+	}
+	if time.Since(mt.CreatedAt) > magicTokenTTL {
+		sent["Error"] = "Este código ha expirado (15 min)"
+		renderTemplate(w, "login_sent.html", sent)
+		return
+	}
+	// The attempt is counted before comparing, in a single statement, so
+	// concurrent requests can't go past the limit.
+	var attempts, recent int
+	err = db.QueryRow(`UPDATE magic_tokens SET code_attempts = code_attempts + 1
+		WHERE id = ? AND code != '' AND code_attempts < ? RETURNING code_attempts`, mt.ID, maxCodeAttempts).Scan(&attempts)
+	db.QueryRow(`SELECT COALESCE(SUM(code_attempts), 0) FROM magic_tokens
+		WHERE email = ? AND created_at > datetime('now', '-1 hour')`, mt.Email).Scan(&recent)
+	if err != nil || recent > maxEmailCodeAttempts {
+		sent["Error"] = "Demasiados intentos. Solicita un nuevo enlace."
+		renderTemplate(w, "login_sent.html", sent)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(code), []byte(mt.Code)) != 1 {
+		left := min(maxCodeAttempts-attempts, maxEmailCodeAttempts-recent)
+		if left <= 0 {
+			sent["Error"] = "Demasiados intentos. Solicita un nuevo enlace."
+		} else {
+			sent["Error"] = fmt.Sprintf("Código incorrecto. Te quedan %d intentos.", left)
+		}
+		renderTemplate(w, "login_sent.html", sent)
+		return
+	}
+
+	if u, err := userByEmail(mt.Email); err == nil && u.DeactivatedAt != nil {
+		renderTemplate(w, "login.html", loginData(errAccountDeactivated))
+		return
+	}
+
+	db.Exec("UPDATE magic_tokens SET approved_at = CURRENT_TIMESTAMP WHERE id = ?", mt.ID)
+	u, msg := approvedUser(mt.Email)
+	if u == nil {
+		renderTemplate(w, "login.html", signupData(msg))
+		return
+	}
+
+	if totpRequired(u) {
+		http.Redirect(w, r, totpURL(token), http.StatusSeeOther)
+		return
+	}
+
+	// The code was typed in the waiting browser, so polling can't log in
+	// another one.
+	consumeMagicToken(mt.ID)
+	startSession(w, r, u.ID)
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+// handleTOTP is the second step of an email login for users with TOTP
+// enabled. Admins without TOTP are enrolled here when it is enforced.
+func handleTOTP(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	mt, err := getMagicToken(token)
+	if err != nil || mt.ApprovedAt == nil {
+		renderTemplate(w, "totp.html", map[string]any{"Fatal": "Enlace inválido o expirado"})
+		return
+	}
+	if mt.MFAVerifiedAt != nil {
+		renderTemplate(w, "totp.html", map[string]any{"Fatal": "Este enlace ya ha sido utilizado"})
+		return
+	}
+	if time.Since(*mt.ApprovedAt) > magicTokenTTL {
+		renderTemplate(w, "totp.html", map[string]any{"Fatal": "Este enlace ha expirado (15 min)"})
+		return
+	}
+	if mt.MFAAttempts >= maxCodeAttempts {
+		renderTemplate(w, "totp.html", map[string]any{"Fatal": "Demasiados intentos. Solicita un nuevo enlace."})
+		return
+	}
+	u, err := userByEmail(mt.Email)
+	if err != nil || u.DeactivatedAt != nil || !totpRequired(u) {
+		renderTemplate(w, "totp.html", map[string]any{"Fatal": "Enlace inválido o expirado"})
+		return
+	}
+
+	data := map[string]any{"Token": token, "Email": u.Email, "Enroll": !u.TOTPEnabled}
+	if !u.TOTPEnabled {
+		secret := pendingTOTPSecret(u.ID)
+		data["Secret"] = formatTOTPSecret(secret)
+		data["URI"] = totpURI(u.Email, secret)
+	}
+	if r.Method == "GET" {
+		renderTemplate(w, "totp.html", data)
+		return
+	}
+
+	// As with login codes, the attempt is counted before checking, in a
+	// single statement, so concurrent requests can't go past the limit.
+	var attempts int
+	err = db.QueryRow(`UPDATE magic_tokens SET mfa_attempts = mfa_attempts + 1
+		WHERE id = ? AND mfa_verified_at IS NULL AND mfa_attempts < ? RETURNING mfa_attempts`, mt.ID, maxCodeAttempts).Scan(&attempts)
+	if err != nil {
+		renderTemplate(w, "totp.html", map[string]any{"Fatal": "Demasiados intentos. Solicita un nuevo enlace."})
+		return
+	}
+	code := r.FormValue("code")
+	var ok bool
+	if u.TOTPEnabled {
+		ok = verifySecondFactor(u.ID, code)
+	} else {
+		ok = confirmTOTPEnrollment(u.ID, code)
+	}
+	if !ok {
+		data["Error"] = "Código incorrecto"
+		renderTemplate(w, "totp.html", data)
+		return
+	}
+
+	err = db.QueryRow("UPDATE magic_tokens SET mfa_verified_at = CURRENT_TIMESTAMP WHERE id = ? AND mfa_verified_at IS NULL RETURNING id", mt.ID).
+		Scan(&mt.ID)
+	if err != nil {
+		renderTemplate(w, "totp.html", map[string]any{"Fatal": "Este enlace ya ha sido utilizado"})
+		return
+	}
+	// After SSO or a passkey this browser is the one the login was for;
+	// email logins leave the session of the waiting browser to /auth/status.
+	if mt.Code == "" && !consumeMagicToken(mt.ID) {
+		renderTemplate(w, "totp.html", map[string]any{"Fatal": "Este enlace ya ha sido utilizado"})
+		return
+	}
+	startSession(w, r, u.ID)
+
+	if !u.TOTPEnabled {
+		// Freshly enrolled: show recovery codes once before continuing
+		renderTemplate(w, "totp.html", map[string]any{"RecoveryCodes": regenerateRecoveryCodes(u.ID)})
+		return
+	}
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
 func handleLogout(w http.ResponseWriter, r *http.Request) {
 	cookie, err := r.Cookie("session")
 	if err == nil {
+		var uid int64
+		if db.QueryRow("SELECT user_id FROM sessions WHERE token = ?", cookie.Value).Scan(&uid) == nil {
+			if u, err := loadUser(uid); err == nil {
+				recordAudit(r, u, "auth.logout", "")
+			}
+		}
 		db.Exec("DELETE FROM sessions WHERE token = ?", cookie.Value)
 	}
 	http.SetCookie(w, &http.Cookie{Name: "session", MaxAge: -1, Path: "/"})
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
-func sendMagicEmail(to, link string) {
+func sendMagicEmail(to, link, code string) {
 	if cfg.ResendAPIKey == "" {
-		log.Printf("RESEND_API_KEY not configured, magic link: %s", link)
+		log.Printf("RESEND_API_KEY not configured, magic link: %s (code %s)", link, code)
 		return
 	}
 
@@ -170,31 +442,11 @@ func sendMagicEmail(to, link string) {
 <h2 style="color:#333">Acceso a Portal</h2>
 <p>Haz clic en el siguiente botón para iniciar sesión:</p>
 <a href="%s" style="display:inline-block;background:#2563eb;color:#fff;padding:12px 24px;border-radius:6px;text-decoration:none;font-weight:bold">Iniciar sesión</a>
-<p style="margin-top:24px;color:#666;font-size:14px">Este enlace caduca en 15 minutos.</p>
+<p style="margin-top:24px">O introduce este código en la página de inicio de sesión:</p>
+<p style="font-size:28px;font-weight:bold;letter-spacing:6px;color:#333">%s</p>
+<p style="margin-top:24px;color:#666;font-size:14px">Este enlace y el código caducan en 15 minutos.</p>
 <p style="color:#999;font-size:12px">Si no solicitaste este enlace, ignora este mensaje.</p>
-</div>`, link)
-
-	payload, _ := json.Marshal(map[string]any{
-		"from":    "Portal <portal@mentasystems.com>",
-		"to":      []string{to},
-		"subject": "Tu enlace de acceso",
-		"html":    htmlBody,
-	})
-
-	req, _ := http.NewRequest("POST", "https://api.resend.com/emails", bytes.NewReader(payload))
-	req.Header.Set("Authorization", "Bearer "+cfg.ResendAPIKey)
-	req.Header.Set("Content-Type", "application/json")
+</div>`, link, code)
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Printf("Failed to send email to %s: %v", to, err)
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 300 {
-		var resErr map[string]any
-		json.NewDecoder(resp.Body).Decode(&resErr)
-		log.Printf("Resend API error (%d) sending to %s: %v", resp.StatusCode, to, resErr)
-	}
+	sendEmail(to, "Tu enlace de acceso", htmlBody)
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// projectBoard groups the issues into one column per status of the project's
+// workflow, in the manual order set by dragging cards.
+func projectBoard(projectID int64, f issueFilter) []BoardColumn {
+	issues := projectIssues(projectID)
+	sort.SliceStable(issues, func(a, b int) bool {
+		if issues[a].Position != issues[b].Position {
+			return issues[a].Position < issues[b].Position
+		}
+		return issues[a].CreatedAt.Before(issues[b].CreatedAt)
+	})
+	statuses := projectStatuses(projectID)
+	columns := make([]BoardColumn, len(statuses))
+	index := map[string]int{}
+	for i, s := range statuses {
+		columns[i].Status = s
+		index[s.Key] = i
+	}
+	for _, issue := range issues {
+		if c, ok := index[issue.Status]; ok && f.match(&issue) {
+			columns[c].Issues = append(columns[c].Issues, issue)
+		}
+	}
+	return columns
+}
+
+// handleBoard renders the board alone, for the HTMX filters.
+func handleBoard(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	p, role := getProjectForUser(r.PathValue("slug"), u)
+	if p == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	f := issueFilterFromRequest(r, projectCustomFields(p.ID))
+	renderTemplate(w, "board", map[string]any{
+		"Project": p,
+		"Access":  projectAccess(p.ID, u, role),
+		"Board":   projectBoard(p.ID, f),
+		"Filter":  f,
+	})
+}
+
+// handleReorderIssues moves an issue to a column and position. order is the
+// sequence of card ids the user sees in the destination column after the
+// drop; both affected columns are renumbered in one transaction.
+func handleReorderIssues(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	p, _, ok := authorizeProject(w, r, permIssuesEdit)
+	if !ok {
+		return
+	}
+
+	id, _ := strconv.ParseInt(r.FormValue("issue_id"), 10, 64)
+	status := r.FormValue("status")
+	if !validStatus(p.ID, status) {
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+	var order []int64
+	for _, s := range strings.Split(r.FormValue("order"), ",") {
+		if oid, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+			order = append(order, oid)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var prevStatus, title string
+	if err := tx.QueryRow("SELECT status, title FROM issues WHERE id = ? AND project_id = ?", id, p.ID).Scan(&prevStatus, &title); err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	column := columnIssueIDs(tx, p.ID, status, id)
+	column = placeIssue(column, id, order)
+	if prevStatus != status {
+		tx.Exec("UPDATE issues SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", status, id)
+		renumberIssues(tx, columnIssueIDs(tx, p.ID, prevStatus, id))
+	}
+	renumberIssues(tx, column)
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if prevStatus != status {
+		recordIssueEvent(r, u, p.ID, id, "status", &prevStatus, &status)
+		recordChange(r, u, p.ID, "issue.update", title,
+			map[string]any{"id": id, "status": prevStatus}, map[string]any{"id": id, "status": status})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// columnIssueIDs lists a status column in board order, leaving out skip.
+func columnIssueIDs(tx *sql.Tx, projectID int64, status string, skip int64) []int64 {
+	rows, err := tx.Query("SELECT id FROM issues WHERE project_id = ? AND status = ? AND id != ? ORDER BY position, created_at, id",
+		projectID, status, skip)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// placeIssue inserts id into column next to its neighbours in order. The
+// board may be filtered, so order can be a subset of the column; issues the
+// user couldn't see keep their relative place.
+func placeIssue(column []int64, id int64, order []int64) []int64 {
+	indexOf := func(ids []int64, v int64) int {
+		for i, x := range ids {
+			if x == v {
+				return i
+			}
+		}
+		return -1
+	}
+	insert := func(at int) []int64 {
+		out := append([]int64{}, column[:at]...)
+		out = append(out, id)
+		return append(out, column[at:]...)
+	}
+	pos := indexOf(order, id)
+	if pos < 0 {
+		return append(column, id)
+	}
+	for _, next := range order[pos+1:] {
+		if k := indexOf(column, next); k >= 0 {
+			return insert(k)
+		}
+	}
+	for i := pos - 1; i >= 0; i-- {
+		if k := indexOf(column, order[i]); k >= 0 {
+			return insert(k + 1)
+		}
+	}
+	return append(column, id)
+}
+
+func renumberIssues(tx *sql.Tx, ids []int64) {
+	for i, id := range ids {
+		tx.Exec("UPDATE issues SET position = ? WHERE id = ?", i+1, id)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestPlaceIssue(t *testing.T) {
+	tests := []struct {
+		name   string
+		column []int64
+		order  []int64
+		want   []int64
+	}{
+		{"empty column", nil, []int64{9}, []int64{9}},
+		{"to the top", []int64{1, 2, 3}, []int64{9, 1, 2, 3}, []int64{9, 1, 2, 3}},
+		{"between", []int64{1, 2, 3}, []int64{1, 9, 2, 3}, []int64{1, 9, 2, 3}},
+		{"to the bottom", []int64{1, 2, 3}, []int64{1, 2, 3, 9}, []int64{1, 2, 3, 9}},
+		{"not in order", []int64{1, 2}, []int64{1, 2}, []int64{1, 2, 9}},
+		// 2 and 4 are hidden by a filter: the card goes before the next
+		// visible one and the hidden cards keep their place.
+		{"filtered, before next", []int64{1, 2, 3, 4, 5}, []int64{1, 9, 3, 5}, []int64{1, 2, 9, 3, 4, 5}},
+		{"filtered, after previous", []int64{1, 2, 3}, []int64{1, 9}, []int64{1, 9, 2, 3}},
+		{"unknown neighbours", []int64{1, 2}, []int64{7, 9, 8}, []int64{1, 2, 9}},
+	}
+	for _, tt := range tests {
+		if got := placeIssue(tt.column, 9, tt.order); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: placeIssue(%v, 9, %v) = %v, want %v", tt.name, tt.column, tt.order, got, tt.want)
+		}
+	}
+}
+
+func TestReorderIssues(t *testing.T) {
+	openTestDB(t)
+	users := seedProject(t)
+	seedProjectStatuses(1)
+	mustExec(t, `INSERT INTO issues (id, project_id, number, title, status, position) VALUES
+		(1, 1, 1, 'A', 'todo', 1), (2, 1, 2, 'B', 'todo', 2), (3, 1, 3, 'C', 'todo', 3),
+		(4, 1, 4, 'D', 'done', 1), (5, 1, 5, 'E', 'done', 2)`)
+	column := func(status string) []int64 {
+		var ids []int64
+		rows, _ := db.Query("SELECT id FROM issues WHERE status = ? ORDER BY position", status)
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			rows.Scan(&id)
+			ids = append(ids, id)
+		}
+		return ids
+	}
+	reorder := func(u *User, id, status, order string) int {
+		return postAs(handleReorderIssues, u, url.Values{"issue_id": {id}, "status": {status}, "order": {order}}, "slug", "acme").Code
+	}
+
+	if code := reorder(users["member"], "2", "done", "4,2,5"); code != http.StatusNoContent {
+		t.Fatalf("reorder: %d", code)
+	}
+	if got := column("todo"); !reflect.DeepEqual(got, []int64{1, 3}) {
+		t.Errorf("source column %v, want [1 3]", got)
+	}
+	if got := column("done"); !reflect.DeepEqual(got, []int64{4, 2, 5}) {
+		t.Errorf("destination column %v, want [4 2 5]", got)
+	}
+	if n := auditCount(t, "issue.update"); n != 1 {
+		t.Errorf("%d audit entries for the status change, want 1", n)
+	}
+
+	reorder(users["member"], "3", "todo", "3,1")
+	if got := column("todo"); !reflect.DeepEqual(got, []int64{3, 1}) {
+		t.Errorf("within column %v, want [3 1]", got)
+	}
+	if n := auditCount(t, "issue.update"); n != 1 {
+		t.Errorf("%d audit entries after moving within a column, want 1", n)
+	}
+
+	if code := reorder(users["client"], "1", "done", "1"); code != http.StatusForbidden {
+		t.Errorf("client reordering: %d, want 403", code)
+	}
+	if code := reorder(users["member"], "1", "nonexistent", "1"); code != http.StatusBadRequest {
+		t.Errorf("unknown status: %d, want 400", code)
+	}
+}
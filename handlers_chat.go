@@ -28,6 +28,7 @@ func handleSendMessage(w http.ResponseWriter, r *http.Request) {
 		renderTemplate(w, "chat_messages", map[string]any{
 			"Messages": msgs,
 			"User":     u,
+			"Project":  p,
 		})
 		return
 	}
@@ -52,6 +53,7 @@ func handleChatPoll(w http.ResponseWriter, r *http.Request) {
 	renderTemplate(w, "chat_messages", map[string]any{
 		"Messages": msgs,
 		"User":     u,
+		"Project":  p,
 	})
 }
 
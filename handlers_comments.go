@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// issueComments returns the top-level comments of an issue, oldest first,
+// with their replies nested under them.
+func issueComments(issueID int64) []*Comment {
+	rows, err := db.Query(`
+		SELECT c.id, c.issue_id, c.parent_id, c.user_id, c.body, c.created_at, c.deleted_at,
+			u.email, u.name, u.avatar_path
+		FROM issue_comments c
+		LEFT JOIN users u ON u.id = c.user_id
+		WHERE c.issue_id = ?
+		ORDER BY c.id`, issueID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var all []*Comment
+	byID := map[int64]*Comment{}
+	for rows.Next() {
+		c := &Comment{}
+		var email, name, avatar *string
+		rows.Scan(&c.ID, &c.IssueID, &c.ParentID, &c.UserID, &c.Body, &c.CreatedAt, &c.DeletedAt,
+			&email, &name, &avatar)
+		if c.UserID != nil {
+			c.User = &User{ID: *c.UserID, Email: deref(email), Name: deref(name), AvatarPath: deref(avatar)}
+		}
+		all = append(all, c)
+		byID[c.ID] = c
+	}
+
+	var roots []*Comment
+	for _, c := range all {
+		if c.ParentID != nil && byID[*c.ParentID] != nil {
+			parent := byID[*c.ParentID]
+			parent.Replies = append(parent.Replies, c)
+			continue
+		}
+		roots = append(roots, c)
+	}
+	return roots
+}
+
+// handleCreateComment adds a comment or, with parent_id, a reply. Anyone with
+// permComment can post, including clients who can't edit the issue itself.
+func handleCreateComment(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	p, _, ok := authorizeProject(w, r, permComment)
+	if !ok {
+		return
+	}
+	issue := projectIssue(p.ID, resolveIssueID(p.ID, r.PathValue("id")))
+	if issue == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	body := strings.TrimSpace(r.FormValue("body"))
+	if body == "" {
+		http.Error(w, "Comment required", http.StatusBadRequest)
+		return
+	}
+	var parentID *int64
+	if pid, _ := strconv.ParseInt(r.FormValue("parent_id"), 10, 64); pid > 0 {
+		var exists bool
+		db.QueryRow("SELECT EXISTS(SELECT 1 FROM issue_comments WHERE id = ? AND issue_id = ?)", pid, issue.ID).Scan(&exists)
+		if !exists {
+			http.Error(w, "Invalid parent", http.StatusBadRequest)
+			return
+		}
+		parentID = &pid
+	}
+
+	res, err := db.Exec("INSERT INTO issue_comments (issue_id, parent_id, user_id, body) VALUES (?, ?, ?, ?)",
+		issue.ID, parentID, u.ID, body)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	commentID, _ := res.LastInsertId()
+	recordChange(r, u, p.ID, "comment.create", issue.Title, nil, map[string]any{
+		"id": commentID, "issue_id": issue.ID, "parent_id": parentID, "body": auditExcerpt(body),
+	})
+	http.Redirect(w, r, fmt.Sprintf("%s#comment-%d", issuePath(p, issue.ID), commentID), http.StatusSeeOther)
+}
+
+// handleDeleteComment blanks a comment, leaving a placeholder so its replies
+// keep their thread. Authors can delete their own; managers any.
+func handleDeleteComment(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	p, role := getProjectForUser(r.PathValue("slug"), u)
+	if p == nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	issueID := resolveIssueID(p.ID, r.PathValue("id"))
+	commentID, _ := strconv.ParseInt(r.PathValue("cid"), 10, 64)
+
+	var authorID *int64
+	var body, title string
+	err := db.QueryRow(`SELECT c.user_id, c.body, i.title FROM issue_comments c
+		JOIN issues i ON i.id = c.issue_id
+		WHERE c.id = ? AND c.issue_id = ? AND i.project_id = ? AND c.deleted_at IS NULL`,
+		commentID, issueID, p.ID).Scan(&authorID, &body, &title)
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	own := authorID != nil && *authorID == u.ID
+	if !own && !projectAccess(p.ID, u, role).Can(permManage) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	db.Exec("UPDATE issue_comments SET body = '', deleted_at = CURRENT_TIMESTAMP WHERE id = ?", commentID)
+	recordChange(r, u, p.ID, "comment.delete", title,
+		map[string]any{"id": commentID, "issue_id": issueID, "body": auditExcerpt(body)}, nil)
+	http.Redirect(w, r, issuePath(p, issueID)+"#comments", http.StatusSeeOther)
+}
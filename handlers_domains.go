@@ -0,0 +1,191 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// errSignupPending is shown when a domain rule matched but an admin has to
+// approve the account first.
+const errSignupPending = "Tu solicitud de acceso está pendiente de aprobación. Te avisaremos por email."
+
+func emailDomain(email string) string {
+	return strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+}
+
+func domainRules(domain string) []DomainRule {
+	query := `SELECT r.id, r.domain, r.project_id, r.role, r.require_approval, r.created_at, p.name, p.slug
+		FROM domain_rules r JOIN projects p ON p.id = r.project_id`
+	var args []any
+	if domain != "" {
+		query += ` WHERE r.domain = ?`
+		args = append(args, domain)
+	}
+	rows, err := db.Query(query+` ORDER BY r.domain, p.name`, args...)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var rules []DomainRule
+	for rows.Next() {
+		var dr DomainRule
+		p := &Project{}
+		rows.Scan(&dr.ID, &dr.Domain, &dr.ProjectID, &dr.Role, &dr.RequireApproval, &dr.CreatedAt, &p.Name, &p.Slug)
+		p.ID = dr.ProjectID
+		dr.Project = p
+		rules = append(rules, dr)
+	}
+	return rules
+}
+
+// signupBlocked returns why an unknown email can't sign up through a domain
+// rule, or "" when it can.
+func signupBlocked(email string) string {
+	if len(domainRules(emailDomain(email))) == 0 {
+		return "No existe una cuenta con ese email"
+	}
+	var rejected bool
+	if db.QueryRow("SELECT rejected_at IS NOT NULL FROM signup_requests WHERE email = ?", email).Scan(&rejected) == nil {
+		if rejected {
+			return "No existe una cuenta con ese email"
+		}
+		return errSignupPending
+	}
+	return ""
+}
+
+// provisionFromDomain creates the account for an unknown email when a domain
+// rule matches. It returns the new user, or a message for the login page when
+// no rule applies or an admin must approve first. Callers must have proven
+// the address: an approved login link or code, or an SSO login.
+func provisionFromDomain(email, name string) (*User, string) {
+	if msg := signupBlocked(email); msg != "" {
+		return nil, msg
+	}
+	rules := domainRules(emailDomain(email))
+	for _, dr := range rules {
+		if dr.RequireApproval {
+			db.Exec("INSERT OR IGNORE INTO signup_requests (email, name) VALUES (?, ?)", email, name)
+			log.Printf("Sign-up from %s waiting for approval", email)
+			return nil, errSignupPending
+		}
+	}
+
+	u, err := createProvisionedUser(email, name, rules)
+	if err != nil {
+		return nil, "Error al crear usuario"
+	}
+	log.Printf("Provisioned user via domain rule: %s", email)
+	return u, ""
+}
+
+func createProvisionedUser(email, name string, rules []DomainRule) (*User, error) {
+	if name == "" {
+		name = strings.Split(email, "@")[0]
+	}
+	res, err := db.Exec("INSERT INTO users (email, name, role) VALUES (?, ?, 'client')", email, name)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	for _, dr := range rules {
+		db.Exec("INSERT OR IGNORE INTO project_members (project_id, user_id, role) VALUES (?, ?, ?)", dr.ProjectID, id, dr.Role)
+	}
+	return &User{ID: id, Email: email, Name: name, Role: "client"}, nil
+}
+
+func pendingSignups() []SignupRequest {
+	rows, err := db.Query(`SELECT id, email, name, created_at FROM signup_requests
+		WHERE rejected_at IS NULL ORDER BY created_at`)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var reqs []SignupRequest
+	for rows.Next() {
+		var sr SignupRequest
+		rows.Scan(&sr.ID, &sr.Email, &sr.Name, &sr.CreatedAt)
+		reqs = append(reqs, sr)
+	}
+	for i := range reqs {
+		reqs[i].Rules = domainRules(emailDomain(reqs[i].Email))
+	}
+	return reqs
+}
+
+func handleAdminDomains(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u.Role != "admin" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	renderTemplate(w, "admin_domains.html", map[string]any{
+		"User":      u,
+		"Projects":  userProjects(u),
+		"Rules":     domainRules(""),
+		"Signups":   pendingSignups(),
+		"Roles":     []string{"client", "member", "owner"},
+		"Error":     r.URL.Query().Get("error"),
+		"AdminPage": "domains",
+	})
+}
+
+func handleAdminUpdateDomains(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u.Role != "admin" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	fail := func(msg string) {
+		http.Redirect(w, r, "/admin/domains?"+url.Values{"error": {msg}}.Encode(), http.StatusSeeOther)
+	}
+
+	switch r.FormValue("action") {
+	case "add_rule":
+		domain := strings.TrimPrefix(strings.TrimSpace(strings.ToLower(r.FormValue("domain"))), "@")
+		if domain == "" || strings.ContainsAny(domain, "@ /") || !strings.Contains(domain, ".") {
+			fail("Dominio no válido")
+			return
+		}
+		role := r.FormValue("role")
+		if role != "client" && role != "member" && role != "owner" {
+			role = "client"
+		}
+		projectID, _ := strconv.ParseInt(r.FormValue("project_id"), 10, 64)
+		_, err := db.Exec(`INSERT INTO domain_rules (domain, project_id, role, require_approval, created_by)
+			VALUES (?, ?, ?, ?, ?)`, domain, projectID, role, r.FormValue("require_approval") == "1", u.ID)
+		if err != nil {
+			fail("Ya existe una regla para ese dominio y proyecto")
+			return
+		}
+		recordAudit(r, u, "domain_rule.create", domain)
+	case "delete_rule":
+		var domain string
+		db.QueryRow("SELECT domain FROM domain_rules WHERE id = ?", r.FormValue("rule_id")).Scan(&domain)
+		db.Exec("DELETE FROM domain_rules WHERE id = ?", r.FormValue("rule_id"))
+		recordAudit(r, u, "domain_rule.delete", domain)
+	case "approve_signup":
+		var email, name string
+		err := db.QueryRow("SELECT email, name FROM signup_requests WHERE id = ? AND rejected_at IS NULL", r.FormValue("signup_id")).
+			Scan(&email, &name)
+		if err != nil {
+			break
+		}
+		if _, err := createProvisionedUser(email, name, domainRules(emailDomain(email))); err != nil {
+			fail("No se pudo crear el usuario")
+			return
+		}
+		db.Exec("DELETE FROM signup_requests WHERE email = ?", email)
+		recordAudit(r, u, "signup.approve", email)
+		go sendSignupApprovedEmail(email)
+	case "reject_signup":
+		var email string
+		db.QueryRow("SELECT email FROM signup_requests WHERE id = ?", r.FormValue("signup_id")).Scan(&email)
+		db.Exec("UPDATE signup_requests SET rejected_at = CURRENT_TIMESTAMP WHERE id = ?", r.FormValue("signup_id"))
+		recordAudit(r, u, "signup.reject", email)
+	}
+	http.Redirect(w, r, "/admin/domains", http.StatusSeeOther)
+}
@@ -13,9 +13,8 @@ import (
 func handleUploadFile(w http.ResponseWriter, r *http.Request) {
 	slug := r.PathValue("slug")
 	u := currentUser(r)
-	p, role := getProjectForUser(slug, u)
-	if p == nil || role == "client" {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	p, _, ok := authorizeProject(w, r, permFilesUpload)
+	if !ok {
 		return
 	}
 
@@ -56,6 +55,8 @@ func handleUploadFile(w http.ResponseWriter, r *http.Request) {
 
 	db.Exec(`INSERT INTO files (project_id, folder_id, name, path, size, mime_type, uploaded_by)
 		VALUES (?, ?, ?, ?, ?, ?, ?)`, p.ID, folderID, header.Filename, diskPath, size, mime, u.ID)
+	recordChange(r, u, p.ID, "file.upload", header.Filename, nil, map[string]any{"size": size, "folder_id": folderID})
+	go notifyFileUploaded(p, u, header.Filename)
 
 	folderQuery := ""
 	if folderID != nil {
@@ -67,9 +68,8 @@ func handleUploadFile(w http.ResponseWriter, r *http.Request) {
 func handleCreateFolder(w http.ResponseWriter, r *http.Request) {
 	slug := r.PathValue("slug")
 	u := currentUser(r)
-	p, role := getProjectForUser(slug, u)
-	if p == nil || role == "client" {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	p, _, ok := authorizeProject(w, r, permFilesUpload)
+	if !ok {
 		return
 	}
 
@@ -88,6 +88,7 @@ func handleCreateFolder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	db.Exec("INSERT INTO folders (project_id, parent_id, name) VALUES (?, ?, ?)", p.ID, parentID, name)
+	recordChange(r, u, p.ID, "folder.create", name, nil, map[string]any{"parent_id": parentID})
 
 	folderQuery := ""
 	if parentID != nil {
@@ -127,19 +128,24 @@ func handleDownloadFile(w http.ResponseWriter, r *http.Request) {
 func handleDeleteFile(w http.ResponseWriter, r *http.Request) {
 	slug := r.PathValue("slug")
 	u := currentUser(r)
-	p, role := getProjectForUser(slug, u)
-	if p == nil || role == "client" {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	p, _, ok := authorizeProject(w, r, permFilesDelete)
+	if !ok {
 		return
 	}
 
 	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
-	var path string
-	db.QueryRow("SELECT path FROM files WHERE id = ? AND project_id = ?", id, p.ID).Scan(&path)
-	if path != "" {
+	var path, name, mime string
+	var size int64
+	var folderID, uploadedBy *int64
+	err := db.QueryRow("SELECT path, name, size, mime_type, folder_id, uploaded_by FROM files WHERE id = ? AND project_id = ?", id, p.ID).
+		Scan(&path, &name, &size, &mime, &folderID, &uploadedBy)
+	if err == nil {
 		os.Remove(path)
+		db.Exec("DELETE FROM files WHERE id = ? AND project_id = ?", id, p.ID)
+		recordChange(r, u, p.ID, "file.delete", name, map[string]any{
+			"id": id, "size": size, "mime_type": mime, "folder_id": folderID, "uploaded_by": uploadedBy,
+		}, nil)
 	}
-	db.Exec("DELETE FROM files WHERE id = ? AND project_id = ?", id, p.ID)
 	http.Redirect(w, r, "/projects/"+slug+"?tab=files", http.StatusSeeOther)
 }
 
@@ -186,7 +192,7 @@ func projectFiles(projectID int64, folderIDStr string) ([]Folder, []File) {
 	if folderID == nil {
 		rows, err := db.Query(`
 			SELECT f.id, f.project_id, f.folder_id, f.name, f.path, f.size, f.mime_type, f.uploaded_by, f.created_at,
-				u.id, u.name
+				u.id, u.name, u.avatar_path
 			FROM files f LEFT JOIN users u ON u.id = f.uploaded_by
 			WHERE f.project_id = ? AND f.folder_id IS NULL ORDER BY f.name`, projectID)
 		if err == nil {
@@ -194,10 +200,10 @@ func projectFiles(projectID int64, folderIDStr string) ([]Folder, []File) {
 			for rows.Next() {
 				var f File
 				var uid *int64
-				var uname *string
-				rows.Scan(&f.ID, &f.ProjectID, &f.FolderID, &f.Name, &f.Path, &f.Size, &f.MimeType, &f.UploadedBy, &f.CreatedAt, &uid, &uname)
+				var uname, uavatar *string
+				rows.Scan(&f.ID, &f.ProjectID, &f.FolderID, &f.Name, &f.Path, &f.Size, &f.MimeType, &f.UploadedBy, &f.CreatedAt, &uid, &uname, &uavatar)
 				if uid != nil {
-					f.Uploader = &User{ID: *uid, Name: deref(uname)}
+					f.Uploader = &User{ID: *uid, Name: deref(uname), AvatarPath: deref(uavatar)}
 				}
 				files = append(files, f)
 			}
@@ -205,7 +211,7 @@ func projectFiles(projectID int64, folderIDStr string) ([]Folder, []File) {
 	} else {
 		rows, err := db.Query(`
 			SELECT f.id, f.project_id, f.folder_id, f.name, f.path, f.size, f.mime_type, f.uploaded_by, f.created_at,
-				u.id, u.name
+				u.id, u.name, u.avatar_path
 			FROM files f LEFT JOIN users u ON u.id = f.uploaded_by
 			WHERE f.project_id = ? AND f.folder_id = ? ORDER BY f.name`, projectID, *folderID)
 		if err == nil {
@@ -213,10 +219,10 @@ func projectFiles(projectID int64, folderIDStr string) ([]Folder, []File) {
 			for rows.Next() {
 				var f File
 				var uid *int64
-				var uname *string
-				rows.Scan(&f.ID, &f.ProjectID, &f.FolderID, &f.Name, &f.Path, &f.Size, &f.MimeType, &f.UploadedBy, &f.CreatedAt, &uid, &uname)
+				var uname, uavatar *string
+				rows.Scan(&f.ID, &f.ProjectID, &f.FolderID, &f.Name, &f.Path, &f.Size, &f.MimeType, &f.UploadedBy, &f.CreatedAt, &uid, &uname, &uavatar)
 				if uid != nil {
-					f.Uploader = &User{ID: *uid, Name: deref(uname)}
+					f.Uploader = &User{ID: *uid, Name: deref(uname), AvatarPath: deref(uavatar)}
 				}
 				files = append(files, f)
 			}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// canImpersonate reports whether admin may view the portal as target. Other
+// admins and deactivated accounts are off limits.
+func canImpersonate(admin, target *User) bool {
+	return admin.Role == "admin" && admin.DeactivatedAt == nil &&
+		target.ID != admin.ID && target.Role != "admin" && target.DeactivatedAt == nil
+}
+
+func handleImpersonate(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u.Role != "admin" || u.Impersonator != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	target, err := loadUser(id)
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if !canImpersonate(u, target) {
+		back := url.Values{"error": {"No se puede ver el portal como este usuario"}}
+		http.Redirect(w, r, "/admin/users?"+back.Encode(), http.StatusSeeOther)
+		return
+	}
+	cookie, _ := r.Cookie("session")
+	db.Exec("UPDATE sessions SET impersonating_id = ? WHERE token = ?", target.ID, cookie.Value)
+	target.Impersonator = u
+	recordAudit(r, target, "impersonation.start", target.Email)
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+func handleStopImpersonation(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u.Impersonator == nil {
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+	cookie, _ := r.Cookie("session")
+	db.Exec("UPDATE sessions SET impersonating_id = NULL WHERE token = ?", cookie.Value)
+	recordAudit(r, u, "impersonation.stop", u.Email)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const invitationTTL = 7 * 24 * time.Hour
+
+// createInvitation records a pending invite for email and sends it. Any
+// previous pending invite for the same address and project is replaced.
+func createInvitation(p *Project, inviter *User, email, role string) {
+	db.Exec("DELETE FROM invitations WHERE project_id = ? AND email = ? AND accepted_at IS NULL", p.ID, email)
+	token := generateToken()
+	db.Exec("INSERT INTO invitations (project_id, email, role, token, invited_by, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		p.ID, email, role, token, inviter.ID, time.Now().Add(invitationTTL))
+	go sendInviteEmail(email, p.Name, inviter.Name, cfg.BaseURL+"/invite/"+token)
+}
+
+// resendInvitation issues a fresh token and expiry for a pending invite so
+// older links stop working. It returns the invited address, or "" if there
+// was no such invite.
+func resendInvitation(p *Project, inviter *User, id string) string {
+	var email string
+	err := db.QueryRow("SELECT email FROM invitations WHERE id = ? AND project_id = ? AND accepted_at IS NULL", id, p.ID).Scan(&email)
+	if err != nil {
+		return ""
+	}
+	token := generateToken()
+	db.Exec("UPDATE invitations SET token = ?, invited_by = ?, expires_at = ?, declined_at = NULL WHERE id = ?",
+		token, inviter.ID, time.Now().Add(invitationTTL), id)
+	go sendInviteEmail(email, p.Name, inviter.Name, cfg.BaseURL+"/invite/"+token)
+	return email
+}
+
+func projectInvitations(projectID int64) []Invitation {
+	rows, err := db.Query(`
+		SELECT i.id, i.project_id, i.email, i.role, i.invited_by, i.created_at, i.expires_at, i.declined_at, u.name
+		FROM invitations i LEFT JOIN users u ON u.id = i.invited_by
+		WHERE i.project_id = ? AND i.accepted_at IS NULL
+		ORDER BY i.created_at`, projectID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var invites []Invitation
+	for rows.Next() {
+		var inv Invitation
+		var uname *string
+		rows.Scan(&inv.ID, &inv.ProjectID, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.CreatedAt, &inv.ExpiresAt, &inv.DeclinedAt, &uname)
+		if inv.InvitedBy != nil {
+			inv.Inviter = &User{ID: *inv.InvitedBy, Name: deref(uname)}
+		}
+		inv.Expired = time.Now().After(inv.ExpiresAt)
+		invites = append(invites, inv)
+	}
+	return invites
+}
+
+func invitationByToken(token string) (*Invitation, error) {
+	inv := &Invitation{Project: &Project{}}
+	var inviterName *string
+	err := db.QueryRow(`
+		SELECT i.id, i.project_id, i.email, i.role, i.token, i.invited_by, i.created_at, i.expires_at,
+			i.accepted_at, i.declined_at, p.name, p.slug, u.name
+		FROM invitations i
+		JOIN projects p ON p.id = i.project_id
+		LEFT JOIN users u ON u.id = i.invited_by
+		WHERE i.token = ?`, token).
+		Scan(&inv.ID, &inv.ProjectID, &inv.Email, &inv.Role, &inv.Token, &inv.InvitedBy, &inv.CreatedAt, &inv.ExpiresAt,
+			&inv.AcceptedAt, &inv.DeclinedAt, &inv.Project.Name, &inv.Project.Slug, &inviterName)
+	if err != nil {
+		return nil, err
+	}
+	inv.Project.ID = inv.ProjectID
+	if inv.InvitedBy != nil {
+		inv.Inviter = &User{ID: *inv.InvitedBy, Name: deref(inviterName)}
+	}
+	return inv, nil
+}
+
+func handleInvitation(w http.ResponseWriter, r *http.Request) {
+	inv, err := invitationByToken(r.PathValue("token"))
+	if err != nil {
+		renderTemplate(w, "invite.html", map[string]any{"Error": "Invitación inválida o revocada"})
+		return
+	}
+	if inv.AcceptedAt != nil {
+		renderTemplate(w, "invite.html", map[string]any{"Error": "Esta invitación ya ha sido aceptada"})
+		return
+	}
+	if inv.DeclinedAt != nil {
+		renderTemplate(w, "invite.html", map[string]any{"Error": "Esta invitación fue rechazada"})
+		return
+	}
+	if time.Now().After(inv.ExpiresAt) {
+		renderTemplate(w, "invite.html", map[string]any{"Error": "Esta invitación ha caducado. Pide que te la reenvíen."})
+		return
+	}
+
+	if r.Method == "GET" {
+		renderTemplate(w, "invite.html", map[string]any{"Invitation": inv})
+		return
+	}
+
+	if r.FormValue("action") == "decline" {
+		db.Exec("UPDATE invitations SET declined_at = CURRENT_TIMESTAMP WHERE id = ?", inv.ID)
+		recordChange(r, nil, inv.ProjectID, "invitation.decline", inv.Email, nil, nil)
+		renderTemplate(w, "invite.html", map[string]any{"Declined": true, "Invitation": inv})
+		return
+	}
+
+	u, err := userByEmail(inv.Email)
+	existing := err == nil
+	if err == nil && u.DeactivatedAt != nil {
+		renderTemplate(w, "invite.html", map[string]any{"Error": errAccountDeactivated})
+		return
+	}
+	if err != nil {
+		name := strings.Split(inv.Email, "@")[0]
+		res, err := db.Exec("INSERT INTO users (email, name, role) VALUES (?, ?, 'client')", inv.Email, name)
+		if err != nil {
+			http.Error(w, "Error al crear usuario", http.StatusInternalServerError)
+			return
+		}
+		id, _ := res.LastInsertId()
+		u = &User{ID: id, Email: inv.Email, Name: name, Role: "client"}
+		log.Printf("Created user from invitation: %s", inv.Email)
+	}
+
+	// Someone who is already a member keeps their role: an invite only
+	// grants access, it never changes it.
+	db.Exec("INSERT INTO project_members (project_id, user_id, role) VALUES (?, ?, ?) ON CONFLICT (project_id, user_id) DO NOTHING",
+		inv.ProjectID, u.ID, inv.Role)
+	db.Exec("UPDATE invitations SET accepted_at = CURRENT_TIMESTAMP WHERE id = ?", inv.ID)
+	recordChange(r, u, inv.ProjectID, "invitation.accept", inv.Email, nil, map[string]any{"role": inv.Role})
+
+	// Existing accounts log in as usual; the invite only adds the membership,
+	// so a leaked invite link can't be used to take over the account. New
+	// accounts have no other credential yet, and the link proves the mailbox.
+	if existing || totpRequired(u) {
+		renderTemplate(w, "invite.html", map[string]any{"Accepted": true, "Invitation": inv})
+		return
+	}
+	startSession(w, r, u.ID)
+	http.Redirect(w, r, "/projects/"+inv.Project.Slug, http.StatusSeeOther)
+}
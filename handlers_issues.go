@@ -1,17 +1,18 @@
 package main
 
 import (
+	"encoding/csv"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func handleCreateIssue(w http.ResponseWriter, r *http.Request) {
 	slug := r.PathValue("slug")
 	u := currentUser(r)
-	p, role := getProjectForUser(slug, u)
-	if p == nil || role == "client" {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	p, access, ok := authorizeProject(w, r, permIssuesCreate)
+	if !ok {
 		return
 	}
 
@@ -22,8 +23,8 @@ func handleCreateIssue(w http.ResponseWriter, r *http.Request) {
 	}
 	desc := r.FormValue("description")
 	status := r.FormValue("status")
-	if status == "" {
-		status = "backlog"
+	if !validStatus(p.ID, status) {
+		status = firstStatus(p.ID)
 	}
 	priority := r.FormValue("priority")
 	if priority == "" {
@@ -51,37 +52,70 @@ func handleCreateIssue(w http.ResponseWriter, r *http.Request) {
 	var maxPos int
 	db.QueryRow("SELECT COALESCE(MAX(position), 0) FROM issues WHERE project_id = ? AND status = ?", p.ID, status).Scan(&maxPos)
 
-	db.Exec(`INSERT INTO issues (project_id, title, description, status, priority, assignee_id, due_date, milestone_id, position, created_by)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		p.ID, title, desc, status, priority, assigneeID, dueDate, milestoneID, maxPos+1, u.ID)
+	res, err := db.Exec(`INSERT INTO issues (project_id, number, title, description, status, priority, assignee_id, due_date, milestone_id, position, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.ID, nextIssueNumber(p.ID), title, desc, status, priority, assigneeID, dueDate, milestoneID, maxPos+1, u.ID)
+	if err == nil {
+		id, _ := res.LastInsertId()
+		r.ParseForm()
+		var labelIDs []int64
+		for _, v := range r.Form["label_id"] {
+			if lid, err := strconv.ParseInt(v, 10, 64); err == nil {
+				labelIDs = append(labelIDs, lid)
+			}
+		}
+		setIssueLabels(p.ID, id, labelIDs)
+		recordIssueEvent(r, u, p.ID, id, "status", nil, &status)
+		recordChange(r, u, p.ID, "issue.create", title, nil, map[string]any{
+			"id": id, "status": status, "priority": priority, "assignee_id": assigneeID,
+			"milestone_id": milestoneID, "due_date": dueDate, "labels": issueLabelNames(id),
+		})
+	}
+	go notifyAssigned(p, u, assigneeID, title)
 
 	if isHTMX(r) {
-		renderIssuesTable(w, p, role)
+		renderIssuesTable(w, r, p, access)
 		return
 	}
 	http.Redirect(w, r, "/projects/"+slug+"?tab=issues", http.StatusSeeOther)
 }
 
+// editableIssueFields are the columns handleUpdateIssue accepts.
+var editableIssueFields = map[string]bool{
+	"status": true, "priority": true, "title": true, "description": true, "assignee_id": true, "due_date": true, "milestone_id": true,
+}
+
 func handleUpdateIssue(w http.ResponseWriter, r *http.Request) {
 	slug := r.PathValue("slug")
 	u := currentUser(r)
-	p, role := getProjectForUser(slug, u)
-	if p == nil || role == "client" {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	p, access, ok := authorizeProject(w, r, permIssuesEdit)
+	if !ok {
 		return
 	}
 
-	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	id := resolveIssueID(p.ID, r.PathValue("id"))
 	field := r.FormValue("field")
 	value := r.FormValue("value")
 
+	// Keep the old value for the audit log
+	var auditTitle string
+	var before *string
+	audited := editableIssueFields[field] &&
+		db.QueryRow("SELECT title, CAST("+field+" AS TEXT) FROM issues WHERE id = ? AND project_id = ?", id, p.ID).
+			Scan(&auditTitle, &before) == nil
+
 	switch field {
 	case "status":
+		if !validStatus(p.ID, value) {
+			break
+		}
 		db.Exec("UPDATE issues SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND project_id = ?", value, id, p.ID)
 	case "priority":
 		db.Exec("UPDATE issues SET priority = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND project_id = ?", value, id, p.ID)
 	case "title":
 		db.Exec("UPDATE issues SET title = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND project_id = ?", value, id, p.ID)
+	case "description":
+		db.Exec("UPDATE issues SET description = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND project_id = ?", value, id, p.ID)
 	case "assignee_id":
 		var assignee *int64
 		if value != "" {
@@ -90,13 +124,57 @@ func handleUpdateIssue(w http.ResponseWriter, r *http.Request) {
 				assignee = &aid
 			}
 		}
+		var prev *int64
+		var title string
+		db.QueryRow("SELECT assignee_id, title FROM issues WHERE id = ? AND project_id = ?", id, p.ID).Scan(&prev, &title)
 		db.Exec("UPDATE issues SET assignee_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND project_id = ?", assignee, id, p.ID)
+		if assignee != nil && (prev == nil || *prev != *assignee) {
+			go notifyAssigned(p, u, assignee, title)
+		}
 	case "due_date":
 		var dd *string
 		if value != "" {
 			dd = &value
 		}
 		db.Exec("UPDATE issues SET due_date = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND project_id = ?", dd, id, p.ID)
+	case "labels":
+		r.ParseForm()
+		var ids []int64
+		for _, v := range r.Form["label_id"] {
+			if lid, err := strconv.ParseInt(v, 10, 64); err == nil {
+				ids = append(ids, lid)
+			}
+		}
+		var title string
+		if db.QueryRow("SELECT title FROM issues WHERE id = ? AND project_id = ?", id, p.ID).Scan(&title) == nil {
+			prev := issueLabelNames(id)
+			setIssueLabels(p.ID, id, ids)
+			if current := issueLabelNames(id); !sameValue(prev, current) {
+				db.Exec("UPDATE issues SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+				recordIssueEvent(r, u, p.ID, id, "labels", prev, current)
+				recordChange(r, u, p.ID, "issue.update", title,
+					map[string]any{"id": id, "labels": prev}, map[string]any{"id": id, "labels": current})
+			}
+		}
+	case "custom_field":
+		fieldID, _ := strconv.ParseInt(r.FormValue("field_id"), 10, 64)
+		cf := projectCustomField(p.ID, fieldID)
+		var title string
+		if cf == nil || db.QueryRow("SELECT title FROM issues WHERE id = ? AND project_id = ?", id, p.ID).Scan(&title) != nil {
+			break
+		}
+		v, valid := normalizeFieldValue(cf, value)
+		if !valid {
+			break
+		}
+		prev := issueFieldDisplay(id, cf)
+		setIssueFieldValue(id, cf.ID, v)
+		if current := issueFieldDisplay(id, cf); !sameValue(prev, current) {
+			db.Exec("UPDATE issues SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+			recordIssueEvent(r, u, p.ID, id, "custom:"+cf.Name, prev, current)
+			recordChange(r, u, p.ID, "issue.update", title,
+				map[string]any{"id": id, cf.Name: prev}, map[string]any{"id": id, cf.Name: current})
+		}
 	case "milestone_id":
 		var mid *int64
 		if value != "" {
@@ -107,56 +185,303 @@ func handleUpdateIssue(w http.ResponseWriter, r *http.Request) {
 		}
 		db.Exec("UPDATE issues SET milestone_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND project_id = ?", mid, id, p.ID)
 	}
+	// Only what was stored is recorded: rejected values and no-op edits leave
+	// the column as it was.
+	if after := issueFieldText(id, field); audited && !sameValue(before, after) {
+		recordIssueEvent(r, u, p.ID, id, field, before, after)
+		if field == "description" {
+			b, a := auditExcerpt(deref(before)), auditExcerpt(deref(after))
+			before, after = &b, &a
+		}
+		recordChange(r, u, p.ID, "issue.update", auditTitle,
+			map[string]any{"id": id, field: before}, map[string]any{"id": id, field: after})
+	}
 
+	if r.FormValue("from") == "detail" {
+		http.Redirect(w, r, issuePath(p, id), http.StatusSeeOther)
+		return
+	}
 	if isHTMX(r) {
-		renderIssuesTable(w, p, role)
+		renderIssuesTable(w, r, p, access)
 		return
 	}
 	http.Redirect(w, r, "/projects/"+slug+"?tab=issues", http.StatusSeeOther)
 }
 
+// handleIssue shows one issue with its rendered description and comments.
+// Editing the fields here needs permIssuesEdit; commenting only permComment.
+func handleIssue(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	p, role := getProjectForUser(r.PathValue("slug"), u)
+	if p == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	id := resolveIssueID(p.ID, r.PathValue("id"))
+	issue := projectIssue(p.ID, id)
+	if issue == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	events := issueEvents(issue.ID)
+	renderTemplate(w, "issue.html", map[string]any{
+		"User":          u,
+		"Project":       p,
+		"Projects":      userProjects(u),
+		"Access":        projectAccess(p.ID, u, role),
+		"Issue":         issue,
+		"Comments":      issueComments(issue.ID),
+		"Events":        events,
+		"TimeInStatus":  statusDurations(issue, events, time.Now()),
+		"Members":       projectMembers(p.ID),
+		"Milestones":    projectMilestones(p.ID),
+		"Labels":        projectLabels(p.ID),
+		"Fields":        projectCustomFields(p.ID),
+		"Checklist":     issueChecklist(issue.ID),
+		"Relations":     issueRelations(issue.ID),
+		"RelationKinds": relationKinds,
+		"OtherIssues":   projectIssues(p.ID),
+		"Statuses":      projectStatuses(p.ID),
+		"Priorities":    []string{"low", "medium", "high", "urgent"},
+	})
+}
+
 func handleDeleteIssue(w http.ResponseWriter, r *http.Request) {
 	slug := r.PathValue("slug")
 	u := currentUser(r)
-	p, role := getProjectForUser(slug, u)
-	if p == nil || role == "client" {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	p, access, ok := authorizeProject(w, r, permIssuesDelete)
+	if !ok {
 		return
 	}
-	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
-	db.Exec("DELETE FROM issues WHERE id = ? AND project_id = ?", id, p.ID)
+	id := resolveIssueID(p.ID, r.PathValue("id"))
+	var title, desc, status, priority string
+	var assigneeID, milestoneID *int64
+	var dueDate *string
+	err := db.QueryRow(`SELECT title, description, status, priority, assignee_id, milestone_id, CAST(due_date AS TEXT)
+		FROM issues WHERE id = ? AND project_id = ?`, id, p.ID).
+		Scan(&title, &desc, &status, &priority, &assigneeID, &milestoneID, &dueDate)
+	if err == nil {
+		db.Exec("DELETE FROM issues WHERE id = ? AND project_id = ?", id, p.ID)
+		recordChange(r, u, p.ID, "issue.delete", title, map[string]any{
+			"id": id, "description": auditExcerpt(desc), "status": status, "priority": priority,
+			"assignee_id": assigneeID, "milestone_id": milestoneID, "due_date": dueDate,
+		}, nil)
+	}
 
 	if isHTMX(r) {
-		renderIssuesTable(w, p, role)
+		renderIssuesTable(w, r, p, access)
 		return
 	}
 	http.Redirect(w, r, "/projects/"+slug+"?tab=issues", http.StatusSeeOther)
 }
 
-func renderIssuesTable(w http.ResponseWriter, p *Project, role string) {
+// issueFilter narrows the issues table and the board by milestone, assignee,
+// label and custom field values. "none" selects issues without one; "" means
+// any.
+type issueFilter struct {
+	Milestone string
+	Assignee  string
+	Label     string
+	Fields    map[int64]string // by custom field id, from the cf_<id> values
+
+	fieldTypes map[int64]string
+}
+
+// issueFilterFromRequest reads the filter from the query string or, for the
+// HTMX requests of the issues table, from the form values it includes.
+func issueFilterFromRequest(r *http.Request, fields []CustomField) issueFilter {
+	f := issueFilter{
+		Milestone:  r.FormValue("milestone"),
+		Assignee:   r.FormValue("assignee"),
+		Label:      r.FormValue("label"),
+		Fields:     map[int64]string{},
+		fieldTypes: map[int64]string{},
+	}
+	for _, cf := range fields {
+		if v := strings.TrimSpace(r.FormValue("cf_" + strconv.FormatInt(cf.ID, 10))); v != "" {
+			if n, err := strconv.ParseFloat(v, 64); err == nil && cf.Type == "number" {
+				v = strconv.FormatFloat(n, 'f', -1, 64)
+			}
+			f.Fields[cf.ID] = v
+			f.fieldTypes[cf.ID] = cf.Type
+		}
+	}
+	return f
+}
+
+func matchOptionalID(want string, id *int64) bool {
+	switch want {
+	case "":
+		return true
+	case "none":
+		return id == nil
+	}
+	return id != nil && strconv.FormatInt(*id, 10) == want
+}
+
+func (f issueFilter) match(i *Issue) bool {
+	if !matchOptionalID(f.Milestone, i.MilestoneID) || !matchOptionalID(f.Assignee, i.AssigneeID) {
+		return false
+	}
+	// Text fields match on a substring, the other types on the exact value.
+	for id, want := range f.Fields {
+		got := i.Fields[id].Value
+		switch {
+		case want == "none":
+			if got != "" {
+				return false
+			}
+		case f.fieldTypes[id] == "text":
+			if !strings.Contains(strings.ToLower(got), strings.ToLower(want)) {
+				return false
+			}
+		case got != want:
+			return false
+		}
+	}
+	switch f.Label {
+	case "":
+		return true
+	case "none":
+		return len(i.Labels) == 0
+	}
+	for _, l := range i.Labels {
+		if strconv.FormatInt(l.ID, 10) == f.Label {
+			return true
+		}
+	}
+	return false
+}
+
+func (f issueFilter) apply(issues []Issue) []Issue {
+	var out []Issue
+	for _, i := range issues {
+		if f.match(&i) {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// handleIssuesTable renders the issues table alone, for the HTMX filters.
+func handleIssuesTable(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	p, role := getProjectForUser(r.PathValue("slug"), u)
+	if p == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	renderIssuesTable(w, r, p, projectAccess(p.ID, u, role))
+}
+
+func renderIssuesTable(w http.ResponseWriter, r *http.Request, p *Project, access *Access) {
+	fields := projectCustomFields(p.ID)
+	filter := issueFilterFromRequest(r, fields)
 	data := map[string]any{
-		"Issues":     projectIssues(p.ID),
+		"Issues":     filter.apply(projectIssues(p.ID)),
+		"Filter":     filter,
 		"Members":    projectMembers(p.ID),
 		"Milestones": projectMilestones(p.ID),
+		"Labels":     projectLabels(p.ID),
+		"Fields":     fields,
 		"Project":    p,
-		"IsClient":   role == "client",
-		"Statuses":   []string{"backlog", "todo", "in_progress", "review", "done"},
+		"Access":     access,
+		"Statuses":   projectStatuses(p.ID),
 		"Priorities": []string{"low", "medium", "high", "urgent"},
 	}
 	renderTemplate(w, "issues_table", data)
 }
 
+// handleExportIssues downloads the issues table as CSV, with the same filters
+// and one column per custom field.
+func handleExportIssues(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	p, _ := getProjectForUser(r.PathValue("slug"), u)
+	if p == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	fields := projectCustomFields(p.ID)
+	statuses := projectStatuses(p.ID)
+	issues := issueFilterFromRequest(r, fields).apply(projectIssues(p.ID))
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+p.Slug+`-tareas.csv"`)
+	cw := csv.NewWriter(w)
+	header := []string{"Clave", "Título", "Estado", "Prioridad", "Responsable", "Hito", "Fecha límite", "Etiquetas"}
+	for _, f := range fields {
+		header = append(header, f.Name)
+	}
+	cw.Write(csvRow(header))
+	for _, i := range issues {
+		var assignee, milestone, due string
+		if i.Assignee != nil {
+			assignee = i.Assignee.Name
+		}
+		if i.Milestone != nil {
+			milestone = i.Milestone.Name
+		}
+		if i.DueDate != nil {
+			due, _, _ = strings.Cut(*i.DueDate, "T")
+		}
+		var labels []string
+		for _, l := range i.Labels {
+			labels = append(labels, l.Name)
+		}
+		row := []string{i.Key, i.Title, statusOf(statuses, i.Status).Name, priorityLabel(i.Priority),
+			assignee, milestone, due, strings.Join(labels, ", ")}
+		for _, f := range fields {
+			row = append(row, i.Fields[f.ID].Display)
+		}
+		cw.Write(csvRow(row))
+	}
+	cw.Flush()
+}
+
+// csvRow quotes cells that a spreadsheet would read as a formula, so titles
+// and field values can't run anything when the export is opened.
+func csvRow(cells []string) []string {
+	for n, c := range cells {
+		if c != "" && strings.ContainsRune("=+-@\t\r", rune(c[0])) {
+			cells[n] = "'" + c
+		}
+	}
+	return cells
+}
+
 func projectIssues(projectID int64) []Issue {
+	return queryIssues(`WHERE i.project_id = ?
+		ORDER BY COALESCE(m.position, 999999), i.position, i.created_at`, projectID)
+}
+
+// projectIssue loads a single issue of the project, or nil.
+func projectIssue(projectID, id int64) *Issue {
+	issues := queryIssues("WHERE i.project_id = ? AND i.id = ?", projectID, id)
+	if len(issues) == 0 {
+		return nil
+	}
+	return &issues[0]
+}
+
+// queryIssues selects issues with their assignee, creator and milestone.
+// where is appended to the query and may include ORDER BY.
+func queryIssues(where string, args ...any) []Issue {
 	rows, err := db.Query(`
-		SELECT i.id, i.project_id, i.title, i.description, i.status, i.priority,
+		SELECT i.id, i.project_id, COALESCE(i.number, 0), pr.issue_prefix, i.title, i.description, i.status, i.priority,
 			i.assignee_id, i.due_date, i.milestone_id, i.position, i.created_by, i.created_at, i.updated_at,
-			u.id, u.email, u.name,
-			m.id, m.name, m.position
+			u.id, u.email, u.name, u.avatar_path,
+			c.name,
+			m.id, m.name, m.position,
+			COALESCE(s.category, 'open'),
+			(SELECT COUNT(*) FROM issue_checklist_items WHERE issue_id = i.id),
+			(SELECT COUNT(*) FROM issue_checklist_items WHERE issue_id = i.id AND done)
 		FROM issues i
+		JOIN projects pr ON pr.id = i.project_id
 		LEFT JOIN users u ON u.id = i.assignee_id
+		LEFT JOIN users c ON c.id = i.created_by
 		LEFT JOIN milestones m ON m.id = i.milestone_id
-		WHERE i.project_id = ?
-		ORDER BY COALESCE(m.position, 999999), i.position, i.created_at`, projectID)
+		LEFT JOIN project_statuses s ON s.project_id = i.project_id AND s.key = i.status
+		`+where, args...)
 	if err != nil {
 		return nil
 	}
@@ -164,24 +489,32 @@ func projectIssues(projectID int64) []Issue {
 	var issues []Issue
 	for rows.Next() {
 		var issue Issue
-		var aID, aEmail, aName *string
+		var prefix string
+		var aID, aEmail, aName, aAvatar, cName *string
 		var assigneeID, createdBy, milestoneID *int64
 		var mID *int64
 		var mName *string
 		var mPos *int
 		rows.Scan(
-			&issue.ID, &issue.ProjectID, &issue.Title, &issue.Description,
+			&issue.ID, &issue.ProjectID, &issue.Number, &prefix, &issue.Title, &issue.Description,
 			&issue.Status, &issue.Priority, &assigneeID, &issue.DueDate,
 			&milestoneID, &issue.Position, &createdBy, &issue.CreatedAt, &issue.UpdatedAt,
-			&aID, &aEmail, &aName,
+			&aID, &aEmail, &aName, &aAvatar,
+			&cName,
 			&mID, &mName, &mPos,
+			&issue.StatusCategory,
+			&issue.ChecklistTotal, &issue.ChecklistDone,
 		)
+		issue.Key = issueKey(prefix, issue.Number)
 		issue.AssigneeID = assigneeID
 		issue.CreatedBy = createdBy
 		issue.MilestoneID = milestoneID
 		if aID != nil {
 			id, _ := strconv.ParseInt(*aID, 10, 64)
-			issue.Assignee = &User{ID: id, Email: deref(aEmail), Name: deref(aName)}
+			issue.Assignee = &User{ID: id, Email: deref(aEmail), Name: deref(aName), AvatarPath: deref(aAvatar)}
+		}
+		if createdBy != nil {
+			issue.Creator = &User{ID: *createdBy, Name: deref(cName)}
 		}
 		if mID != nil {
 			pos := 0
@@ -192,6 +525,14 @@ func projectIssues(projectID int64) []Issue {
 		}
 		issues = append(issues, issue)
 	}
+	attachLabels(issues)
+	attachFieldValues(issues)
+	if len(issues) > 0 {
+		blockers := openBlockers(issues[0].ProjectID)
+		for i := range issues {
+			issues[i].BlockedBy = blockers[issues[i].ID]
+		}
+	}
 	return issues
 }
 
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const oidcCookie = "oidc_auth"
+
+var (
+	oidcMu       sync.Mutex
+	oidcProvider *oidc.Provider
+)
+
+// oidcSetup lazily discovers the identity provider so the portal still starts
+// when the IdP is temporarily unreachable.
+func oidcSetup(ctx context.Context) (*oidc.Provider, *oauth2.Config, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if oidcProvider == nil {
+		p, err := oidc.NewProvider(ctx, cfg.OIDCIssuer)
+		if err != nil {
+			return nil, nil, err
+		}
+		oidcProvider = p
+	}
+	oc := &oauth2.Config{
+		ClientID:     cfg.OIDCClientID,
+		ClientSecret: cfg.OIDCClientSecret,
+		Endpoint:     oidcProvider.Endpoint(),
+		RedirectURL:  cfg.BaseURL + "/auth/oidc/callback",
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}
+	return oidcProvider, oc, nil
+}
+
+func handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+	_, oc, err := oidcSetup(r.Context())
+	if err != nil {
+		log.Printf("OIDC discovery failed: %v", err)
+		renderTemplate(w, "login.html", loginData("No se pudo contactar con el proveedor de identidad"))
+		return
+	}
+
+	state := generateToken()
+	nonce := generateToken()
+	verifier := oauth2.GenerateVerifier()
+
+	// state, nonce and PKCE verifier travel together in a short-lived cookie
+	// bound to this browser.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    state + "." + nonce + "." + verifier,
+		Path:     "/auth/oidc",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	url := oc.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+	fail := func(msg string) {
+		renderTemplate(w, "login.html", loginData(msg))
+	}
+
+	cookie, err := r.Cookie(oidcCookie)
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, MaxAge: -1, Path: "/auth/oidc"})
+	if err != nil {
+		fail("La sesión de inicio ha expirado, inténtalo de nuevo")
+		return
+	}
+	parts := strings.SplitN(cookie.Value, ".", 3)
+	if len(parts) != 3 {
+		fail("La sesión de inicio ha expirado, inténtalo de nuevo")
+		return
+	}
+	state, nonce, verifier := parts[0], parts[1], parts[2]
+
+	if e := r.URL.Query().Get("error"); e != "" {
+		log.Printf("OIDC error from provider: %s: %s", e, r.URL.Query().Get("error_description"))
+		fail("El proveedor de identidad rechazó el inicio de sesión")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("state")), []byte(state)) != 1 {
+		fail("Solicitud de inicio de sesión inválida")
+		return
+	}
+
+	provider, oc, err := oidcSetup(r.Context())
+	if err != nil {
+		log.Printf("OIDC discovery failed: %v", err)
+		fail("No se pudo contactar con el proveedor de identidad")
+		return
+	}
+
+	tok, err := oc.Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		fail("No se pudo completar el inicio de sesión")
+		return
+	}
+	rawIDToken, ok := tok.Extra("id_token").(string)
+	if !ok {
+		fail("El proveedor de identidad no devolvió un id_token")
+		return
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: cfg.OIDCClientID}).Verify(r.Context(), rawIDToken)
+	if err != nil {
+		log.Printf("OIDC id_token verification failed: %v", err)
+		fail("No se pudo verificar la identidad")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		fail("Solicitud de inicio de sesión inválida")
+		return
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		fail("No se pudo leer la identidad")
+		return
+	}
+	email := strings.TrimSpace(strings.ToLower(claims.Email))
+	if email == "" || !claims.EmailVerified {
+		fail("El proveedor de identidad no devolvió un email verificado")
+		return
+	}
+	if !oidcDomainAllowed(email) {
+		fail("Tu dominio de email no tiene acceso a este portal")
+		return
+	}
+
+	var uid int64
+	var deactivatedAt *time.Time
+	err = db.QueryRow("SELECT id, deactivated_at FROM users WHERE email = ?", email).Scan(&uid, &deactivatedAt)
+	if deactivatedAt != nil {
+		fail(errAccountDeactivated)
+		return
+	}
+	if err != nil && len(domainRules(emailDomain(email))) > 0 {
+		u, msg := provisionFromDomain(email, strings.TrimSpace(claims.Name))
+		if u == nil {
+			fail(msg)
+			return
+		}
+		uid, err = u.ID, nil
+	}
+	if err != nil {
+		if !cfg.OIDCAutoProvision {
+			fail("No existe una cuenta con ese email")
+			return
+		}
+		name := strings.TrimSpace(claims.Name)
+		if name == "" {
+			name = strings.Split(email, "@")[0]
+		}
+		res, err := db.Exec("INSERT INTO users (email, name, role) VALUES (?, ?, 'user')", email, name)
+		if err != nil {
+			fail("Error al crear usuario")
+			return
+		}
+		uid, _ = res.LastInsertId()
+		log.Printf("Provisioned user via OIDC: %s", email)
+	}
+
+	if u, err := userByEmail(email); err == nil && totpRequired(u) {
+		http.Redirect(w, r, beginSecondFactor(email), http.StatusSeeOther)
+		return
+	}
+	startSession(w, r, uid)
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+func oidcDomainAllowed(email string) bool {
+	if len(cfg.OIDCAllowedDomains) == 0 {
+		return true
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	for _, d := range cfg.OIDCAllowedDomains {
+		if strings.EqualFold(strings.TrimSpace(d), domain) {
+			return true
+		}
+	}
+	return false
+}
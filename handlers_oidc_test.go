@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testClientID = "portal-test"
+
+// mockIdP is an OpenID provider with discovery, a JWKS and a token endpoint
+// that checks PKCE. authorize stands in for the user signing in.
+type mockIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	claims    map[string]any
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockIdP{key: key, grants: map[string]mockGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		enc := base64.RawURLEncoding
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "alg": "RS256", "use": "sig", "kid": "test",
+			"n": enc.EncodeToString(key.N.Bytes()),
+			"e": enc.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		p.mu.Lock()
+		g, ok := p.grants[r.Form.Get("code")]
+		delete(p.grants, r.Form.Get("code"))
+		p.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "at", "token_type": "Bearer", "expires_in": 3600, "id_token": p.sign(g.claims),
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// authorize signs email in at the provider for the request in authURL and
+// returns the query the provider redirects back with.
+func (p *mockIdP) authorize(t *testing.T, authURL, email string, verified bool) url.Values {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil || !strings.HasPrefix(authURL, p.URL+"/authorize") {
+		t.Fatalf("login redirected to %q, not the provider", authURL)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != testClientID {
+		t.Fatalf("authorization request without PKCE or client: %s", u.RawQuery)
+	}
+	code := generateToken()
+	now := time.Now()
+	p.mu.Lock()
+	p.grants[code] = mockGrant{challenge: q.Get("code_challenge"), claims: map[string]any{
+		"iss": p.URL, "sub": email, "aud": testClientID, "iat": now.Unix(), "exp": now.Add(time.Hour).Unix(),
+		"nonce": q.Get("nonce"), "email": email, "email_verified": verified, "name": "Ana Test",
+	}}
+	p.mu.Unlock()
+	return url.Values{"state": {q.Get("state")}, "code": {code}}
+}
+
+func (p *mockIdP) sign(claims map[string]any) string {
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, sum[:])
+	return signed + "." + enc.EncodeToString(sig)
+}
+
+// setupOIDC configures the portal against a new mock provider, with a fresh
+// database, and restores the configuration afterwards.
+func setupOIDC(t *testing.T) *mockIdP {
+	t.Helper()
+	openTestDB(t)
+	initTemplates()
+	idp := newMockIdP(t)
+	saved := cfg
+	t.Cleanup(func() {
+		cfg = saved
+		oidcProvider = nil
+	})
+	cfg.BaseURL = "http://portal.test"
+	cfg.OIDCIssuer = idp.URL
+	cfg.OIDCClientID = testClientID
+	cfg.OIDCClientSecret = "secret"
+	cfg.OIDCAllowedDomains = nil
+	cfg.OIDCAutoProvision = true
+	cfg.RequireAdminTOTP = false
+	oidcProvider = nil
+	return idp
+}
+
+// oidcLogin runs the portal side of a sign-in: it starts at
+// /auth/oidc/login, lets back adjust what the provider sends back, and
+// returns the callback response.
+func oidcLogin(t *testing.T, idp *mockIdP, email string, verified bool, back func(url.Values)) *http.Response {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleOIDCLogin(rec, httptest.NewRequest("GET", "/auth/oidc/login", nil))
+	res := rec.Result()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("login: status %d", res.StatusCode)
+	}
+	query := idp.authorize(t, res.Header.Get("Location"), email, verified)
+	if back != nil {
+		back(query)
+	}
+	req := httptest.NewRequest("GET", "/auth/oidc/callback?"+query.Encode(), nil)
+	for _, c := range res.Cookies() {
+		req.AddCookie(c)
+	}
+	rec = httptest.NewRecorder()
+	handleOIDCCallback(rec, req)
+	return rec.Result()
+}
+
+func sessionCookie(res *http.Response) string {
+	for _, c := range res.Cookies() {
+		if c.Name == "session" && c.MaxAge >= 0 {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+func TestOIDCLoginProvisionsUser(t *testing.T) {
+	idp := setupOIDC(t)
+	res := oidcLogin(t, idp, "Ana@Example.com", true, nil)
+	if res.StatusCode != http.StatusSeeOther || res.Header.Get("Location") != "/dashboard" {
+		t.Fatalf("callback: status %d, location %q", res.StatusCode, res.Header.Get("Location"))
+	}
+	token := sessionCookie(res)
+	if token == "" {
+		t.Fatal("no session cookie")
+	}
+	var email, name string
+	err := db.QueryRow("SELECT u.email, u.name FROM sessions s JOIN users u ON u.id = s.user_id WHERE s.token = ?", token).
+		Scan(&email, &name)
+	if err != nil || email != "ana@example.com" || name != "Ana Test" {
+		t.Errorf("session user %q %q, err %v", email, name, err)
+	}
+}
+
+func TestOIDCLoginRequiresTOTP(t *testing.T) {
+	idp := setupOIDC(t)
+	cfg.RequireAdminTOTP = true
+	mustExec(t, "INSERT INTO users (email, name, role) VALUES ('admin@example.com', 'Admin', 'admin')")
+
+	res := oidcLogin(t, idp, "admin@example.com", true, nil)
+	loc := res.Header.Get("Location")
+	if res.StatusCode != http.StatusSeeOther || !strings.HasPrefix(loc, "/auth/totp?token=") {
+		t.Fatalf("callback: status %d, location %q; want the TOTP step", res.StatusCode, loc)
+	}
+	if sessionCookie(res) != "" {
+		t.Error("session started before the second factor")
+	}
+	var approved bool
+	db.QueryRow("SELECT approved_at IS NOT NULL FROM magic_tokens WHERE token = ? AND email = 'admin@example.com'",
+		strings.TrimPrefix(loc, "/auth/totp?token=")).Scan(&approved)
+	if !approved {
+		t.Error("TOTP step has no approved login to finish")
+	}
+}
+
+func TestOIDCLoginRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		verified bool
+		setup    func()
+		back     func(url.Values)
+	}{
+		{name: "state mismatch", email: "ana@example.com", verified: true,
+			back: func(q url.Values) { q.Set("state", "forged") }},
+		{name: "unknown code", email: "ana@example.com", verified: true,
+			back: func(q url.Values) { q.Set("code", "forged") }},
+		{name: "unverified email", email: "ana@example.com"},
+		{name: "domain not allowed", email: "ana@other.example", verified: true,
+			setup: func() { cfg.OIDCAllowedDomains = []string{"example.com"} }},
+		{name: "no account", email: "ana@example.com", verified: true,
+			setup: func() { cfg.OIDCAutoProvision = false }},
+		{name: "deactivated", email: "ana@example.com", verified: true,
+			setup: func() {
+				db.Exec("INSERT INTO users (email, name, deactivated_at) VALUES ('ana@example.com', 'Ana', CURRENT_TIMESTAMP)")
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := setupOIDC(t)
+			if tt.setup != nil {
+				tt.setup()
+			}
+			res := oidcLogin(t, idp, tt.email, tt.verified, tt.back)
+			if res.StatusCode != http.StatusOK || sessionCookie(res) != "" {
+				t.Errorf("status %d, location %q; want the login page without a session",
+					res.StatusCode, res.Header.Get("Location"))
+			}
+		})
+	}
+}
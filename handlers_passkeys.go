@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const webauthnCookie = "webauthn"
+
+var webAuthn *webauthn.WebAuthn
+
+func initWebAuthn() {
+	u, err := url.Parse(cfg.BaseURL)
+	if err != nil {
+		log.Printf("Passkeys disabled: invalid base_url: %v", err)
+		return
+	}
+	webAuthn, err = webauthn.New(&webauthn.Config{
+		RPID:          u.Hostname(),
+		RPDisplayName: "Portal",
+		RPOrigins:     []string{u.Scheme + "://" + u.Host},
+	})
+	if err != nil {
+		log.Printf("Passkeys disabled: %v", err)
+	}
+}
+
+// passkeyUser adapts a User and its stored credentials to webauthn.User.
+type passkeyUser struct {
+	*User
+	credentials []webauthn.Credential
+}
+
+func (pu *passkeyUser) WebAuthnID() []byte {
+	return userHandle(pu.ID)
+}
+
+func (pu *passkeyUser) WebAuthnName() string {
+	return pu.Email
+}
+
+func (pu *passkeyUser) WebAuthnDisplayName() string {
+	return pu.Name
+}
+
+func (pu *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return pu.credentials
+}
+
+func userHandle(userID int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(userID))
+	return b
+}
+
+func loadPasskeyUser(userID int64) (*passkeyUser, error) {
+	var u User
+	err := db.QueryRow("SELECT id, email, name, role, deactivated_at FROM users WHERE id = ?", userID).
+		Scan(&u.ID, &u.Email, &u.Name, &u.Role, &u.DeactivatedAt)
+	if err != nil {
+		return nil, err
+	}
+	pu := &passkeyUser{User: &u}
+	rows, err := db.Query("SELECT credential FROM passkeys WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var raw string
+		var c webauthn.Credential
+		rows.Scan(&raw)
+		if json.Unmarshal([]byte(raw), &c) == nil {
+			pu.credentials = append(pu.credentials, c)
+		}
+	}
+	return pu, nil
+}
+
+func userPasskeys(userID int64) []Passkey {
+	rows, err := db.Query(`SELECT id, user_id, name, created_at, last_used_at
+		FROM passkeys WHERE user_id = ? ORDER BY created_at`, userID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var keys []Passkey
+	for rows.Next() {
+		var k Passkey
+		rows.Scan(&k.ID, &k.UserID, &k.Name, &k.CreatedAt, &k.LastUsedAt)
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// saveCeremony stores the WebAuthn session data server-side and binds it to
+// this browser with a short-lived cookie.
+func saveCeremony(w http.ResponseWriter, userID *int64, sd *webauthn.SessionData) {
+	data, _ := json.Marshal(sd)
+	token := generateToken()
+	db.Exec("INSERT INTO webauthn_sessions (token, user_id, data) VALUES (?, ?, ?)", token, userID, string(data))
+	http.SetCookie(w, &http.Cookie{
+		Name:     webauthnCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int((5 * time.Minute).Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// takeCeremony loads and deletes the session data started by saveCeremony.
+func takeCeremony(w http.ResponseWriter, r *http.Request) (*webauthn.SessionData, *int64, error) {
+	cookie, err := r.Cookie(webauthnCookie)
+	if err != nil {
+		return nil, nil, errors.New("missing ceremony cookie")
+	}
+	http.SetCookie(w, &http.Cookie{Name: webauthnCookie, MaxAge: -1, Path: "/"})
+
+	var raw string
+	var userID *int64
+	var createdAt time.Time
+	err = db.QueryRow("SELECT data, user_id, created_at FROM webauthn_sessions WHERE token = ?", cookie.Value).
+		Scan(&raw, &userID, &createdAt)
+	db.Exec("DELETE FROM webauthn_sessions WHERE token = ? OR created_at < ?", cookie.Value, time.Now().Add(-time.Hour))
+	if err != nil {
+		return nil, nil, err
+	}
+	if time.Since(createdAt) > 5*time.Minute {
+		return nil, nil, errors.New("ceremony expired")
+	}
+	var sd webauthn.SessionData
+	if err := json.Unmarshal([]byte(raw), &sd); err != nil {
+		return nil, nil, err
+	}
+	return &sd, userID, nil
+}
+
+func writeJSONError(w http.ResponseWriter, msg string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+func handlePasskeyLoginBegin(w http.ResponseWriter, r *http.Request) {
+	if webAuthn == nil {
+		writeJSONError(w, "Passkeys no disponibles", http.StatusNotFound)
+		return
+	}
+	assertion, sd, err := webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationPreferred))
+	if err != nil {
+		writeJSONError(w, "No se pudo iniciar el acceso", http.StatusInternalServerError)
+		return
+	}
+	saveCeremony(w, nil, sd)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(assertion)
+}
+
+func handlePasskeyLoginFinish(w http.ResponseWriter, r *http.Request) {
+	if webAuthn == nil {
+		writeJSONError(w, "Passkeys no disponibles", http.StatusNotFound)
+		return
+	}
+	sd, _, err := takeCeremony(w, r)
+	if err != nil {
+		writeJSONError(w, "La solicitud ha expirado, inténtalo de nuevo", http.StatusBadRequest)
+		return
+	}
+
+	var pu *passkeyUser
+	handler := func(rawID, handle []byte) (webauthn.User, error) {
+		var userID int64
+		err := db.QueryRow("SELECT user_id FROM passkeys WHERE credential_id = ?", rawID).Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+		if string(handle) != string(userHandle(userID)) {
+			return nil, errors.New("user handle mismatch")
+		}
+		pu, err = loadPasskeyUser(userID)
+		if err == nil && pu.DeactivatedAt != nil {
+			return nil, errors.New("account deactivated")
+		}
+		return pu, err
+	}
+	cred, err := webAuthn.FinishDiscoverableLogin(handler, *sd, r)
+	if err != nil {
+		log.Printf("Passkey login failed: %v", err)
+		writeJSONError(w, "No se pudo verificar la passkey", http.StatusUnauthorized)
+		return
+	}
+	if cred.Authenticator.CloneWarning {
+		log.Printf("Passkey clone warning for user %d", pu.ID)
+		writeJSONError(w, "No se pudo verificar la passkey", http.StatusUnauthorized)
+		return
+	}
+
+	data, _ := json.Marshal(cred)
+	db.Exec("UPDATE passkeys SET credential = ?, last_used_at = CURRENT_TIMESTAMP WHERE credential_id = ?", string(data), cred.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	if u, err := userByEmail(pu.Email); err == nil && totpRequired(u) {
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "redirect": beginSecondFactor(u.Email)})
+		return
+	}
+	startSession(w, r, pu.ID)
+	w.Write([]byte(`{"ok":true}`))
+}
+
+func handlePasskeyRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u.Impersonator != nil {
+		writeJSONError(w, "No disponible al ver el portal como otro usuario", http.StatusForbidden)
+		return
+	}
+	if webAuthn == nil {
+		writeJSONError(w, "Passkeys no disponibles", http.StatusNotFound)
+		return
+	}
+	pu, err := loadPasskeyUser(u.ID)
+	if err != nil {
+		writeJSONError(w, "Usuario no encontrado", http.StatusInternalServerError)
+		return
+	}
+	var exclude []protocol.CredentialDescriptor
+	for _, c := range pu.credentials {
+		exclude = append(exclude, c.Descriptor())
+	}
+	creation, sd, err := webAuthn.BeginRegistration(pu,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(exclude),
+	)
+	if err != nil {
+		writeJSONError(w, "No se pudo iniciar el registro", http.StatusInternalServerError)
+		return
+	}
+	saveCeremony(w, &u.ID, sd)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(creation)
+}
+
+func handlePasskeyRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u.Impersonator != nil {
+		writeJSONError(w, "No disponible al ver el portal como otro usuario", http.StatusForbidden)
+		return
+	}
+	if webAuthn == nil {
+		writeJSONError(w, "Passkeys no disponibles", http.StatusNotFound)
+		return
+	}
+	sd, ceremonyUser, err := takeCeremony(w, r)
+	if err != nil || ceremonyUser == nil || *ceremonyUser != u.ID {
+		writeJSONError(w, "La solicitud ha expirado, inténtalo de nuevo", http.StatusBadRequest)
+		return
+	}
+	pu, err := loadPasskeyUser(u.ID)
+	if err != nil {
+		writeJSONError(w, "Usuario no encontrado", http.StatusInternalServerError)
+		return
+	}
+	cred, err := webAuthn.FinishRegistration(pu, *sd, r)
+	if err != nil {
+		log.Printf("Passkey registration failed for %s: %v", u.Email, err)
+		writeJSONError(w, "No se pudo registrar la passkey", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		name = "Passkey"
+	}
+	data, _ := json.Marshal(cred)
+	_, err = db.Exec("INSERT INTO passkeys (user_id, credential_id, name, credential) VALUES (?, ?, ?, ?)",
+		u.ID, cred.ID, name, string(data))
+	if err != nil {
+		writeJSONError(w, "Esta passkey ya está registrada", http.StatusConflict)
+		return
+	}
+	recordAudit(r, u, "passkey.add", name)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"ok":true}`))
+}
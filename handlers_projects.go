@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -40,13 +39,16 @@ func handleCreateProject(w http.ResponseWriter, r *http.Request) {
 	}
 	slug := makeSlug(name)
 
-	res, err := db.Exec("INSERT INTO projects (name, slug, description) VALUES (?, ?, ?)", name, slug, desc)
+	res, err := db.Exec("INSERT INTO projects (name, slug, description, issue_prefix) VALUES (?, ?, ?, ?)",
+		name, slug, desc, uniqueIssuePrefix(slug))
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 	pid, _ := res.LastInsertId()
 	db.Exec("INSERT INTO project_members (project_id, user_id, role) VALUES (?, ?, 'owner')", pid, u.ID)
+	seedProjectStatuses(pid)
+	recordChange(r, u, pid, "project.create", name, nil, map[string]any{"slug": slug, "description": desc})
 
 	http.Redirect(w, r, "/projects/"+slug, http.StatusSeeOther)
 }
@@ -65,22 +67,24 @@ func handleProject(w http.ResponseWriter, r *http.Request) {
 		tab = "milestones"
 	}
 
-	isClient := role == "client"
-	isOwnerOrAdmin := role == "owner" || u.Role == "admin"
-
 	data := map[string]any{
-		"User":          u,
-		"Project":       p,
-		"Projects":      userProjects(u),
-		"Tab":           tab,
-		"IsClient":      isClient,
-		"IsOwnerOrAdmin": isOwnerOrAdmin,
+		"User":     u,
+		"Project":  p,
+		"Projects": userProjects(u),
+		"Tab":      tab,
+		"Access":   projectAccess(p.ID, u, role),
 	}
 
-	data["Issues"] = projectIssues(p.ID)
+	fields := projectCustomFields(p.ID)
+	filter := issueFilterFromRequest(r, fields)
+	data["Issues"] = filter.apply(projectIssues(p.ID))
+	data["Board"] = projectBoard(p.ID, filter)
+	data["Filter"] = filter
 	data["Members"] = projectMembers(p.ID)
 	data["Milestones"] = projectMilestones(p.ID)
-	data["Statuses"] = []string{"backlog", "todo", "in_progress", "review", "done"}
+	data["Labels"] = projectLabels(p.ID)
+	data["Fields"] = fields
+	data["Statuses"] = projectStatuses(p.ID)
 	data["Priorities"] = []string{"low", "medium", "high", "urgent"}
 
 	folderID := r.URL.Query().Get("folder")
@@ -108,9 +112,8 @@ func handleProject(w http.ResponseWriter, r *http.Request) {
 func handleProjectSettings(w http.ResponseWriter, r *http.Request) {
 	slug := r.PathValue("slug")
 	u := currentUser(r)
-	p, role := getProjectForUser(slug, u)
-	if p == nil || (role != "owner" && u.Role != "admin") {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	p, access, ok := authorizeProject(w, r, permManage)
+	if !ok {
 		return
 	}
 
@@ -120,56 +123,330 @@ func handleProjectSettings(w http.ResponseWriter, r *http.Request) {
 		case "add_member":
 			email := strings.TrimSpace(strings.ToLower(r.FormValue("email")))
 			memberRole := r.FormValue("role")
-			if memberRole != "member" && memberRole != "client" && memberRole != "owner" {
+			if !validProjectRole(p.ID, memberRole) {
 				memberRole = "client"
 			}
 			if email == "" {
 				http.Error(w, "Email required", http.StatusBadRequest)
 				return
 			}
-			// Create user if doesn't exist, always as client
+			// Already a member: just update the role
 			var uid int64
-			err := db.QueryRow("SELECT id FROM users WHERE email = ?", email).Scan(&uid)
-			if err != nil {
-				// User doesn't exist, create as client
-				name := strings.Split(email, "@")[0]
-				res, err := db.Exec("INSERT INTO users (email, name, role) VALUES (?, ?, 'client')", email, name)
-				if err != nil {
-					http.Error(w, "Error al crear usuario", http.StatusInternalServerError)
+			var prevRole string
+			err := db.QueryRow("SELECT pm.user_id, pm.role FROM project_members pm JOIN users u ON u.id = pm.user_id WHERE pm.project_id = ? AND u.email = ?",
+				p.ID, email).Scan(&uid, &prevRole)
+			if !access.canGrant(p.ID, u, memberRole) || (err == nil && !access.canGrant(p.ID, u, prevRole)) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if err == nil {
+				db.Exec("UPDATE project_members SET role = ? WHERE project_id = ? AND user_id = ?", memberRole, p.ID, uid)
+				recordChange(r, u, p.ID, "member.role", email, map[string]any{"role": prevRole}, map[string]any{"role": memberRole})
+				break
+			}
+			createInvitation(p, u, email, memberRole)
+			recordChange(r, u, p.ID, "invitation.create", email, nil, map[string]any{"role": memberRole})
+		case "set_member_role":
+			uid := r.FormValue("user_id")
+			memberRole := r.FormValue("role")
+			var email, prevRole string
+			err := db.QueryRow("SELECT u.email, pm.role FROM project_members pm JOIN users u ON u.id = pm.user_id WHERE pm.project_id = ? AND pm.user_id = ?", p.ID, uid).
+				Scan(&email, &prevRole)
+			if err == nil && (!access.canGrant(p.ID, u, memberRole) || !access.canGrant(p.ID, u, prevRole)) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if err == nil && validProjectRole(p.ID, memberRole) && memberRole != prevRole {
+				db.Exec("UPDATE project_members SET role = ? WHERE project_id = ? AND user_id = ?", memberRole, p.ID, uid)
+				recordChange(r, u, p.ID, "member.role", email, map[string]any{"role": prevRole}, map[string]any{"role": memberRole})
+			}
+		case "create_role":
+			name := strings.TrimSpace(strings.ToLower(r.FormValue("name")))
+			if _, builtin := builtinRoles[name]; builtin || name == "admin" || !roleNameRe.MatchString(name) {
+				renderProjectSettings(w, u, p, access, map[string]any{"RoleError": "Nombre de rol no válido: usa minúsculas, números, - o _ y no repitas un rol existente"})
+				return
+			}
+			perms := permissionsFromForm(r)
+			if !access.holdsAll(perms) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if _, err := db.Exec("INSERT INTO project_roles (project_id, name, permissions) VALUES (?, ?, ?)", p.ID, name, strings.Join(perms, " ")); err != nil {
+				renderProjectSettings(w, u, p, access, map[string]any{"RoleError": "Ya existe un rol con ese nombre"})
+				return
+			}
+			recordChange(r, u, p.ID, "role.create", name, nil, map[string]any{"permissions": perms})
+		case "update_role":
+			var name, prev string
+			if db.QueryRow("SELECT name, permissions FROM project_roles WHERE id = ? AND project_id = ?", r.FormValue("role_id"), p.ID).Scan(&name, &prev) == nil {
+				perms := permissionsFromForm(r)
+				if !access.canGrant(p.ID, u, name) || !access.holdsAll(perms) {
+					http.Error(w, "Forbidden", http.StatusForbidden)
 					return
 				}
-				uid, _ = res.LastInsertId()
-				// Send magic link email so they can log in
-				token := generateToken()
-				db.Exec("INSERT INTO magic_tokens (email, token) VALUES (?, ?)", email, token)
-				link := fmt.Sprintf("%s/auth/approve?token=%s", cfg.BaseURL, token)
-				go sendMagicEmail(email, link)
-			}
-			db.Exec("INSERT OR REPLACE INTO project_members (project_id, user_id, role) VALUES (?, ?, ?)", p.ID, uid, memberRole)
+				db.Exec("UPDATE project_roles SET permissions = ? WHERE id = ?", strings.Join(perms, " "), r.FormValue("role_id"))
+				recordChange(r, u, p.ID, "role.update", name,
+					map[string]any{"permissions": strings.Fields(prev)}, map[string]any{"permissions": perms})
+			}
+		case "delete_role":
+			var name, perms string
+			if db.QueryRow("SELECT name, permissions FROM project_roles WHERE id = ? AND project_id = ?", r.FormValue("role_id"), p.ID).Scan(&name, &perms) == nil {
+				var inUse bool
+				db.QueryRow(`SELECT EXISTS(SELECT 1 FROM project_members WHERE project_id = ? AND role = ?)
+					OR EXISTS(SELECT 1 FROM invitations WHERE project_id = ? AND role = ? AND accepted_at IS NULL)`,
+					p.ID, name, p.ID, name).Scan(&inUse)
+				if inUse {
+					renderProjectSettings(w, u, p, access, map[string]any{"RoleError": "El rol " + name + " está asignado; cambia antes el rol de sus miembros e invitaciones"})
+					return
+				}
+				db.Exec("DELETE FROM project_roles WHERE id = ?", r.FormValue("role_id"))
+				recordChange(r, u, p.ID, "role.delete", name, map[string]any{"permissions": strings.Fields(perms)}, nil)
+			}
+		case "create_label":
+			name := strings.TrimSpace(r.FormValue("name"))
+			color := labelColor(r.FormValue("color"))
+			if name == "" {
+				http.Error(w, "Name required", http.StatusBadRequest)
+				return
+			}
+			if _, err := db.Exec("INSERT INTO labels (project_id, name, color) VALUES (?, ?, ?)", p.ID, name, color); err != nil {
+				renderProjectSettings(w, u, p, access, map[string]any{"LabelError": "Ya existe una etiqueta con ese nombre"})
+				return
+			}
+			recordChange(r, u, p.ID, "label.create", name, nil, map[string]any{"color": color})
+		case "update_label":
+			var prevName, prevColor string
+			if db.QueryRow("SELECT name, color FROM labels WHERE id = ? AND project_id = ?", r.FormValue("label_id"), p.ID).Scan(&prevName, &prevColor) == nil {
+				name := strings.TrimSpace(r.FormValue("name"))
+				if name == "" {
+					name = prevName
+				}
+				color := labelColor(r.FormValue("color"))
+				if _, err := db.Exec("UPDATE labels SET name = ?, color = ? WHERE id = ?", name, color, r.FormValue("label_id")); err != nil {
+					renderProjectSettings(w, u, p, access, map[string]any{"LabelError": "Ya existe una etiqueta con ese nombre"})
+					return
+				}
+				recordChange(r, u, p.ID, "label.update", name,
+					map[string]any{"name": prevName, "color": prevColor}, map[string]any{"name": name, "color": color})
+			}
+		case "delete_label":
+			var name, color string
+			if db.QueryRow("SELECT name, color FROM labels WHERE id = ? AND project_id = ?", r.FormValue("label_id"), p.ID).Scan(&name, &color) == nil {
+				db.Exec("DELETE FROM labels WHERE id = ?", r.FormValue("label_id"))
+				recordChange(r, u, p.ID, "label.delete", name, map[string]any{"color": color}, nil)
+			}
+		case "set_issue_prefix":
+			prefix := strings.ToUpper(strings.TrimSpace(r.FormValue("issue_prefix")))
+			if !issuePrefixRe.MatchString(prefix) {
+				renderProjectSettings(w, u, p, access, map[string]any{"PrefixError": "Prefijo no válido: de 2 a 10 letras o números, empezando por una letra"})
+				return
+			}
+			if prefix != p.IssuePrefix {
+				if _, err := db.Exec("UPDATE projects SET issue_prefix = ? WHERE id = ?", prefix, p.ID); err != nil {
+					renderProjectSettings(w, u, p, access, map[string]any{"PrefixError": "Otro proyecto ya usa el prefijo " + prefix})
+					return
+				}
+				recordChange(r, u, p.ID, "project.issue_prefix", p.Name,
+					map[string]any{"issue_prefix": p.IssuePrefix}, map[string]any{"issue_prefix": prefix})
+			}
+		case "create_field":
+			name := strings.TrimSpace(r.FormValue("name"))
+			fieldType := r.FormValue("type")
+			if name == "" || !validCustomFieldType(fieldType) {
+				http.Error(w, "Name and type required", http.StatusBadRequest)
+				return
+			}
+			options := parseFieldOptions(r.FormValue("options"))
+			if fieldType != "select" {
+				options = nil
+			} else if len(options) == 0 {
+				renderProjectSettings(w, u, p, access, map[string]any{"FieldError": "Un campo de tipo lista necesita al menos una opción"})
+				return
+			}
+			if _, err := db.Exec("INSERT INTO custom_fields (project_id, name, type, options) VALUES (?, ?, ?, ?)",
+				p.ID, name, fieldType, strings.Join(options, "\n")); err != nil {
+				renderProjectSettings(w, u, p, access, map[string]any{"FieldError": "Ya existe un campo con ese nombre"})
+				return
+			}
+			recordChange(r, u, p.ID, "field.create", name, nil, map[string]any{"type": fieldType, "options": options})
+		case "update_field":
+			fieldID, _ := strconv.ParseInt(r.FormValue("field_id"), 10, 64)
+			if f := projectCustomField(p.ID, fieldID); f != nil {
+				name := strings.TrimSpace(r.FormValue("name"))
+				if name == "" {
+					name = f.Name
+				}
+				options := f.Options
+				if f.Type == "select" {
+					if o := parseFieldOptions(r.FormValue("options")); len(o) > 0 {
+						options = o
+					}
+				}
+				if _, err := db.Exec("UPDATE custom_fields SET name = ?, options = ? WHERE id = ?", name, strings.Join(options, "\n"), f.ID); err != nil {
+					renderProjectSettings(w, u, p, access, map[string]any{"FieldError": "Ya existe un campo con ese nombre"})
+					return
+				}
+				recordChange(r, u, p.ID, "field.update", name,
+					map[string]any{"name": f.Name, "options": f.Options}, map[string]any{"name": name, "options": options})
+			}
+		case "delete_field":
+			fieldID, _ := strconv.ParseInt(r.FormValue("field_id"), 10, 64)
+			if f := projectCustomField(p.ID, fieldID); f != nil {
+				db.Exec("DELETE FROM custom_fields WHERE id = ?", f.ID)
+				recordChange(r, u, p.ID, "field.delete", f.Name, map[string]any{"type": f.Type, "options": f.Options, "issues": f.Issues}, nil)
+			}
+		case "create_status":
+			name := strings.TrimSpace(r.FormValue("name"))
+			category := r.FormValue("category")
+			if name == "" || !validStatusCategory(category) {
+				http.Error(w, "Name and category required", http.StatusBadRequest)
+				return
+			}
+			var count, maxPos int
+			db.QueryRow("SELECT COUNT(*), COALESCE(MAX(position), 0) FROM project_statuses WHERE project_id = ?", p.ID).Scan(&count, &maxPos)
+			key := statusKey(name)
+			if key == "" {
+				key = "status_" + strconv.Itoa(count+1)
+			}
+			if _, err := db.Exec("INSERT INTO project_statuses (project_id, key, name, category, position) VALUES (?, ?, ?, ?, ?)",
+				p.ID, key, name, category, maxPos+1); err != nil {
+				renderProjectSettings(w, u, p, access, map[string]any{"StatusError": "Ya existe un estado con ese nombre"})
+				return
+			}
+			recordChange(r, u, p.ID, "status.create", name, nil, map[string]any{"key": key, "category": category})
+		case "update_status":
+			var prevName, prevCategory string
+			if db.QueryRow("SELECT name, category FROM project_statuses WHERE id = ? AND project_id = ?", r.FormValue("status_id"), p.ID).Scan(&prevName, &prevCategory) == nil {
+				name := strings.TrimSpace(r.FormValue("name"))
+				if name == "" {
+					name = prevName
+				}
+				category := r.FormValue("category")
+				if !validStatusCategory(category) {
+					category = prevCategory
+				}
+				db.Exec("UPDATE project_statuses SET name = ?, category = ? WHERE id = ?", name, category, r.FormValue("status_id"))
+				recordChange(r, u, p.ID, "status.update", name,
+					map[string]any{"name": prevName, "category": prevCategory}, map[string]any{"name": name, "category": category})
+			}
+		case "move_status":
+			// Swap positions with the neighbouring status in the chosen direction.
+			statuses := projectStatuses(p.ID)
+			for i, s := range statuses {
+				if strconv.FormatInt(s.ID, 10) != r.FormValue("status_id") {
+					continue
+				}
+				j := i + 1
+				if r.FormValue("direction") == "up" {
+					j = i - 1
+				}
+				if j < 0 || j >= len(statuses) {
+					break
+				}
+				statuses[i], statuses[j] = statuses[j], statuses[i]
+				if renumberStatuses(p.ID, statuses) {
+					recordChange(r, u, p.ID, "status.move", s.Name, map[string]any{"position": i + 1}, map[string]any{"position": j + 1})
+				}
+				break
+			}
+		case "delete_status":
+			var name, key, category string
+			if db.QueryRow("SELECT name, key, category FROM project_statuses WHERE id = ? AND project_id = ?", r.FormValue("status_id"), p.ID).Scan(&name, &key, &category) == nil {
+				var issues, count int
+				db.QueryRow("SELECT COUNT(*) FROM issues WHERE project_id = ? AND status = ?", p.ID, key).Scan(&issues)
+				db.QueryRow("SELECT COUNT(*) FROM project_statuses WHERE project_id = ?", p.ID).Scan(&count)
+				if issues > 0 {
+					renderProjectSettings(w, u, p, access, map[string]any{"StatusError": "El estado " + name + " tiene tareas; muévelas antes a otro estado"})
+					return
+				}
+				if count <= 1 {
+					renderProjectSettings(w, u, p, access, map[string]any{"StatusError": "El proyecto necesita al menos un estado"})
+					return
+				}
+				db.Exec("DELETE FROM project_statuses WHERE id = ?", r.FormValue("status_id"))
+				recordChange(r, u, p.ID, "status.delete", name, map[string]any{"key": key, "category": category}, nil)
+			}
+		case "resend_invite":
+			if email := resendInvitation(p, u, r.FormValue("invite_id")); email != "" {
+				recordChange(r, u, p.ID, "invitation.resend", email, nil, nil)
+			}
+		case "revoke_invite":
+			var email, inviteRole string
+			err := db.QueryRow("SELECT email, role FROM invitations WHERE id = ? AND project_id = ? AND accepted_at IS NULL", r.FormValue("invite_id"), p.ID).
+				Scan(&email, &inviteRole)
+			if err == nil {
+				db.Exec("DELETE FROM invitations WHERE id = ? AND project_id = ? AND accepted_at IS NULL", r.FormValue("invite_id"), p.ID)
+				recordChange(r, u, p.ID, "invitation.revoke", email, map[string]any{"role": inviteRole}, nil)
+			}
 		case "remove_member":
 			uid := r.FormValue("user_id")
-			db.Exec("DELETE FROM project_members WHERE project_id = ? AND user_id = ?", p.ID, uid)
+			var email, memberRole string
+			err := db.QueryRow("SELECT u.email, pm.role FROM project_members pm JOIN users u ON u.id = pm.user_id WHERE pm.project_id = ? AND pm.user_id = ?", p.ID, uid).
+				Scan(&email, &memberRole)
+			if err == nil && !access.canGrant(p.ID, u, memberRole) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if err == nil {
+				db.Exec("DELETE FROM project_members WHERE project_id = ? AND user_id = ?", p.ID, uid)
+				recordChange(r, u, p.ID, "member.remove", email, map[string]any{"role": memberRole}, nil)
+			}
+		case "create_api_key":
+			if !access.canCreateAPIKey(p.ID, u) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			key := generateAPIKey()
+			db.Exec("INSERT INTO api_keys (project_id, key, created_by) VALUES (?, ?, ?)", p.ID, key, u.ID)
+			recordChange(r, u, p.ID, "api_key.create", maskKey(key), nil, nil)
+			// The full key is only shown once
+			renderProjectSettings(w, u, p, access, map[string]any{"NewAPIKey": key})
+			return
+		case "revoke_api_key":
+			var key string
+			if db.QueryRow("SELECT key FROM api_keys WHERE id = ? AND project_id = ?", r.FormValue("key_id"), p.ID).Scan(&key) == nil {
+				db.Exec("DELETE FROM api_keys WHERE id = ? AND project_id = ?", r.FormValue("key_id"), p.ID)
+				recordChange(r, u, p.ID, "api_key.revoke", maskKey(key), nil, nil)
+			}
 		}
 		http.Redirect(w, r, "/projects/"+slug+"/settings", http.StatusSeeOther)
 		return
 	}
 
-	members := projectMembers(p.ID)
-	renderTemplate(w, "project_settings.html", map[string]any{
-		"User":     u,
-		"Project":  p,
-		"Projects": userProjects(u),
-		"Members":  members,
-	})
+	renderProjectSettings(w, u, p, access, nil)
+}
+
+func renderProjectSettings(w http.ResponseWriter, u *User, p *Project, access *Access, extra map[string]any) {
+	data := map[string]any{
+		"User":        u,
+		"Project":     p,
+		"Projects":    userProjects(u),
+		"Access":      access,
+		"Members":     projectMembers(p.ID),
+		"APIKeys":     projectAPIKeys(p.ID),
+		"Invites":     projectInvitations(p.ID),
+		"Roles":       projectRoles(p.ID),
+		"Permissions": allPermissions,
+		"Labels":      projectLabels(p.ID),
+		"Statuses":    projectStatuses(p.ID),
+		"Categories":  statusCategories,
+		"Fields":      projectCustomFields(p.ID),
+		"FieldTypes":  customFieldTypes,
+
+		"CanCreateAPIKey": access.canCreateAPIKey(p.ID, u),
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	renderTemplate(w, "project_settings.html", data)
 }
 
 // Milestone handlers
 func handleCreateMilestone(w http.ResponseWriter, r *http.Request) {
 	slug := r.PathValue("slug")
 	u := currentUser(r)
-	p, role := getProjectForUser(slug, u)
-	if p == nil || role == "client" {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	p, _, ok := authorizeProject(w, r, permMilestonesEdit)
+	if !ok {
 		return
 	}
 
@@ -189,6 +466,7 @@ func handleCreateMilestone(w http.ResponseWriter, r *http.Request) {
 
 	db.Exec(`INSERT INTO milestones (project_id, name, description, target_date, position)
 		VALUES (?, ?, ?, ?, ?)`, p.ID, name, desc, targetDate, maxPos+1)
+	recordChange(r, u, p.ID, "milestone.create", name, nil, map[string]any{"target_date": targetDate})
 
 	http.Redirect(w, r, "/projects/"+slug+"?tab=milestones", http.StatusSeeOther)
 }
@@ -196,9 +474,8 @@ func handleCreateMilestone(w http.ResponseWriter, r *http.Request) {
 func handleUpdateMilestone(w http.ResponseWriter, r *http.Request) {
 	slug := r.PathValue("slug")
 	u := currentUser(r)
-	p, role := getProjectForUser(slug, u)
-	if p == nil || role == "client" {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	p, _, ok := authorizeProject(w, r, permMilestonesEdit)
+	if !ok {
 		return
 	}
 
@@ -206,6 +483,12 @@ func handleUpdateMilestone(w http.ResponseWriter, r *http.Request) {
 	field := r.FormValue("field")
 	value := r.FormValue("value")
 
+	var auditName string
+	var before *string
+	audited := (field == "name" || field == "description" || field == "target_date") &&
+		db.QueryRow("SELECT name, CAST("+field+" AS TEXT) FROM milestones WHERE id = ? AND project_id = ?", id, p.ID).
+			Scan(&auditName, &before) == nil
+
 	switch field {
 	case "name":
 		db.Exec("UPDATE milestones SET name = ? WHERE id = ? AND project_id = ?", value, id, p.ID)
@@ -218,6 +501,14 @@ func handleUpdateMilestone(w http.ResponseWriter, r *http.Request) {
 		}
 		db.Exec("UPDATE milestones SET target_date = ? WHERE id = ? AND project_id = ?", td, id, p.ID)
 	}
+	if audited {
+		var after *string
+		db.QueryRow("SELECT CAST("+field+" AS TEXT) FROM milestones WHERE id = ?", id).Scan(&after)
+		if !sameValue(before, after) {
+			recordChange(r, u, p.ID, "milestone.update", auditName,
+				map[string]any{"id": id, field: before}, map[string]any{"id": id, field: after})
+		}
+	}
 
 	http.Redirect(w, r, "/projects/"+slug+"?tab=milestones", http.StatusSeeOther)
 }
@@ -225,15 +516,19 @@ func handleUpdateMilestone(w http.ResponseWriter, r *http.Request) {
 func handleDeleteMilestone(w http.ResponseWriter, r *http.Request) {
 	slug := r.PathValue("slug")
 	u := currentUser(r)
-	p, role := getProjectForUser(slug, u)
-	if p == nil || role == "client" {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	p, _, ok := authorizeProject(w, r, permMilestonesDelete)
+	if !ok {
 		return
 	}
 	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
-	// Unlink issues from this milestone
-	db.Exec("UPDATE issues SET milestone_id = NULL WHERE milestone_id = ? AND project_id = ?", id, p.ID)
-	db.Exec("DELETE FROM milestones WHERE id = ? AND project_id = ?", id, p.ID)
+	var name string
+	var targetDate *string
+	if db.QueryRow("SELECT name, CAST(target_date AS TEXT) FROM milestones WHERE id = ? AND project_id = ?", id, p.ID).Scan(&name, &targetDate) == nil {
+		// Unlink issues from this milestone
+		db.Exec("UPDATE issues SET milestone_id = NULL WHERE milestone_id = ? AND project_id = ?", id, p.ID)
+		db.Exec("DELETE FROM milestones WHERE id = ? AND project_id = ?", id, p.ID)
+		recordChange(r, u, p.ID, "milestone.delete", name, map[string]any{"id": id, "target_date": targetDate}, nil)
+	}
 
 	http.Redirect(w, r, "/projects/"+slug+"?tab=milestones", http.StatusSeeOther)
 }
@@ -242,7 +537,12 @@ func projectMilestones(projectID int64) []Milestone {
 	rows, err := db.Query(`
 		SELECT m.id, m.project_id, m.name, m.description, m.target_date, m.position, m.created_at,
 			COALESCE((SELECT COUNT(*) FROM issues WHERE milestone_id = m.id), 0),
-			COALESCE((SELECT COUNT(*) FROM issues WHERE milestone_id = m.id AND status = 'done'), 0)
+			COALESCE((SELECT COUNT(*) FROM issues i WHERE i.milestone_id = m.id AND `+statusDoneSQL("i")+`), 0),
+			(SELECT COUNT(*) FROM issue_checklist_items c JOIN issues i ON i.id = c.issue_id WHERE i.milestone_id = m.id),
+			(SELECT COUNT(*) FROM issue_checklist_items c JOIN issues i ON i.id = c.issue_id WHERE i.milestone_id = m.id AND c.done),
+			COALESCE((SELECT SUM(CASE WHEN `+statusDoneSQL("i")+` THEN 1.0
+				ELSE COALESCE((SELECT AVG(c.done) FROM issue_checklist_items c WHERE c.issue_id = i.id), 0) END)
+				FROM issues i WHERE i.milestone_id = m.id), 0)
 		FROM milestones m
 		WHERE m.project_id = ?
 		ORDER BY m.position, m.created_at`, projectID)
@@ -253,8 +553,14 @@ func projectMilestones(projectID int64) []Milestone {
 	var milestones []Milestone
 	for rows.Next() {
 		var ms Milestone
+		var completed float64
 		rows.Scan(&ms.ID, &ms.ProjectID, &ms.Name, &ms.Description, &ms.TargetDate,
-			&ms.Position, &ms.CreatedAt, &ms.TotalIssues, &ms.DoneIssues)
+			&ms.Position, &ms.CreatedAt, &ms.TotalIssues, &ms.DoneIssues,
+			&ms.ChecklistTotal, &ms.ChecklistDone, &completed)
+		// Open issues count for the share of their checklist that is done
+		if ms.TotalIssues > 0 {
+			ms.Progress = int(completed * 100 / float64(ms.TotalIssues))
+		}
 		milestones = append(milestones, ms)
 	}
 	return milestones
@@ -292,8 +598,8 @@ func userProjects(u *User) []Project {
 
 func getProjectForUser(slug string, u *User) (*Project, string) {
 	var p Project
-	err := db.QueryRow("SELECT id, name, slug, description, created_at FROM projects WHERE slug = ?", slug).
-		Scan(&p.ID, &p.Name, &p.Slug, &p.Description, &p.CreatedAt)
+	err := db.QueryRow("SELECT id, name, slug, description, issue_prefix, created_at FROM projects WHERE slug = ?", slug).
+		Scan(&p.ID, &p.Name, &p.Slug, &p.Description, &p.IssuePrefix, &p.CreatedAt)
 	if err != nil {
 		return nil, ""
 	}
@@ -313,9 +619,32 @@ func getProjectForUser(slug string, u *User) (*Project, string) {
 	return &p, role
 }
 
+func projectAPIKeys(projectID int64) []APIKey {
+	rows, err := db.Query(`
+		SELECT ak.id, ak.project_id, ak.key, ak.created_by, ak.created_at, u.name
+		FROM api_keys ak LEFT JOIN users u ON u.id = ak.created_by
+		WHERE ak.project_id = ?
+		ORDER BY ak.created_at`, projectID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var keys []APIKey
+	for rows.Next() {
+		var k APIKey
+		var uname *string
+		rows.Scan(&k.ID, &k.ProjectID, &k.Key, &k.CreatedBy, &k.CreatedAt, &uname)
+		if k.CreatedBy != nil {
+			k.Creator = &User{ID: *k.CreatedBy, Name: deref(uname)}
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
 func projectMembers(projectID int64) []ProjectMember {
 	rows, err := db.Query(`
-		SELECT pm.id, pm.project_id, pm.user_id, pm.role, u.id, u.email, u.name, u.role
+		SELECT pm.id, pm.project_id, pm.user_id, pm.role, u.id, u.email, u.name, u.role, u.avatar_path, u.deactivated_at
 		FROM project_members pm
 		JOIN users u ON u.id = pm.user_id
 		WHERE pm.project_id = ?
@@ -328,7 +657,7 @@ func projectMembers(projectID int64) []ProjectMember {
 	for rows.Next() {
 		var m ProjectMember
 		var u User
-		rows.Scan(&m.ID, &m.ProjectID, &m.UserID, &m.Role, &u.ID, &u.Email, &u.Name, &u.Role)
+		rows.Scan(&m.ID, &m.ProjectID, &m.UserID, &m.Role, &u.ID, &u.Email, &u.Name, &u.Role, &u.AvatarPath, &u.DeactivatedAt)
 		m.User = &u
 		members = append(members, m)
 	}
@@ -0,0 +1,929 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SCIM 2.0 (RFC 7643/7644) provisioning for identity directories. Users map
+// to `users` rows (DELETE deactivates, it never removes the row) and groups
+// map to a project role chosen by an admin in /admin/scim.
+
+const (
+	scimUserSchema  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimListSchema  = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimErrorSchema = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimMaxResults  = 200
+)
+
+func scimAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.SCIMToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.SCIMToken)) != 1 {
+			scimError(w, http.StatusUnauthorized, "", "invalid bearer token")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), auditCredentialKey, "SCIM")))
+	})
+}
+
+func writeSCIM(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func scimError(w http.ResponseWriter, status int, scimType, detail string) {
+	body := map[string]any{
+		"schemas": []string{scimErrorSchema},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+	writeSCIM(w, status, body)
+}
+
+func scimList(w http.ResponseWriter, total, startIndex, n int, resources any) {
+	writeSCIM(w, http.StatusOK, map[string]any{
+		"schemas":      []string{scimListSchema},
+		"totalResults": total,
+		"startIndex":   startIndex,
+		"itemsPerPage": n,
+		"Resources":    resources,
+	})
+}
+
+// scimPage reads the 1-based startIndex and count query parameters.
+func scimPage(r *http.Request) (start, count int) {
+	start, _ = strconv.Atoi(r.URL.Query().Get("startIndex"))
+	if start < 1 {
+		start = 1
+	}
+	count = scimMaxResults
+	if c, err := strconv.Atoi(r.URL.Query().Get("count")); err == nil && c >= 0 && c < scimMaxResults {
+		count = c
+	}
+	return start, count
+}
+
+// scimFilter parses the only filter form directories use for lookups:
+// `attribute eq "value"`.
+func scimFilter(r *http.Request) (attr, value string, ok bool) {
+	f := strings.TrimSpace(r.URL.Query().Get("filter"))
+	if f == "" {
+		return "", "", true
+	}
+	parts := strings.SplitN(f, " ", 3)
+	if len(parts) != 3 || !strings.EqualFold(parts[1], "eq") {
+		return "", "", false
+	}
+	value, err := strconv.Unquote(parts[2])
+	if err != nil {
+		return "", "", false
+	}
+	return strings.ToLower(parts[0]), value, true
+}
+
+type scimMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+type scimRef struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type scimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type scimUser struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	Name        scimName    `json:"name"`
+	DisplayName string      `json:"displayName"`
+	Emails      []scimEmail `json:"emails"`
+	Active      bool        `json:"active"`
+	Groups      []scimRef   `json:"groups"`
+	Meta        scimMeta    `json:"meta"`
+}
+
+type scimGroup struct {
+	Schemas     []string  `json:"schemas"`
+	ID          string    `json:"id"`
+	ExternalID  string    `json:"externalId,omitempty"`
+	DisplayName string    `json:"displayName"`
+	Members     []scimRef `json:"members,omitempty"`
+	Meta        scimMeta  `json:"meta"`
+}
+
+func handleSCIMServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	writeSCIM(w, http.StatusOK, map[string]any{
+		"schemas":        []string{"urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]any{"supported": true, "maxResults": scimMaxResults},
+		"changePassword": map[string]bool{"supported": false},
+		"sort":           map[string]bool{"supported": false},
+		"etag":           map[string]bool{"supported": false},
+		"authenticationSchemes": []map[string]any{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "Static token configured as scim_token",
+			"primary":     true,
+		}},
+	})
+}
+
+func handleSCIMResourceTypes(w http.ResponseWriter, r *http.Request) {
+	types := []map[string]any{
+		{"schemas": []string{"urn:ietf:params:scim:schemas:core:2.0:ResourceType"},
+			"id": "User", "name": "User", "endpoint": "/Users", "schema": scimUserSchema},
+		{"schemas": []string{"urn:ietf:params:scim:schemas:core:2.0:ResourceType"},
+			"id": "Group", "name": "Group", "endpoint": "/Groups", "schema": scimGroupSchema},
+	}
+	writeSCIM(w, http.StatusOK, map[string]any{
+		"schemas":      []string{scimListSchema},
+		"totalResults": len(types),
+		"Resources":    types,
+	})
+}
+
+// Users
+
+func loadSCIMUser(id int64) (*scimUser, error) {
+	var su scimUser
+	var uid int64
+	var deactivatedAt *time.Time
+	err := db.QueryRow(`SELECT id, email, name, scim_external_id, deactivated_at, created_at FROM users WHERE id = ?`, id).
+		Scan(&uid, &su.UserName, &su.DisplayName, &su.ExternalID, &deactivatedAt, &su.Meta.Created)
+	if err != nil {
+		return nil, err
+	}
+	su.Schemas = []string{scimUserSchema}
+	su.ID = strconv.FormatInt(uid, 10)
+	su.Name = scimName{Formatted: su.DisplayName}
+	su.Emails = []scimEmail{{Value: su.UserName, Type: "work", Primary: true}}
+	su.Active = deactivatedAt == nil
+	su.Meta.ResourceType = "User"
+	su.Meta.LastModified = su.Meta.Created
+	if deactivatedAt != nil {
+		su.Meta.LastModified = *deactivatedAt
+	}
+	su.Meta.Location = cfg.BaseURL + "/scim/v2/Users/" + su.ID
+	su.Groups = []scimRef{}
+	rows, err := db.Query(`SELECT g.id, g.display_name FROM scim_group_members gm
+		JOIN scim_groups g ON g.id = gm.group_id WHERE gm.user_id = ? ORDER BY g.display_name`, uid)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var gid int64
+			var ref scimRef
+			rows.Scan(&gid, &ref.Display)
+			ref.Value = strconv.FormatInt(gid, 10)
+			ref.Ref = cfg.BaseURL + "/scim/v2/Groups/" + ref.Value
+			su.Groups = append(su.Groups, ref)
+		}
+	}
+	return &su, nil
+}
+
+func handleSCIMListUsers(w http.ResponseWriter, r *http.Request) {
+	attr, value, ok := scimFilter(r)
+	if !ok {
+		scimError(w, http.StatusBadRequest, "invalidFilter", "only `attribute eq \"value\"` filters are supported")
+		return
+	}
+	where := ""
+	var args []any
+	switch attr {
+	case "":
+	case "username", "emails.value", "emails":
+		where, args = " WHERE email = ?", []any{strings.ToLower(value)}
+	case "externalid":
+		where, args = " WHERE scim_external_id = ?", []any{value}
+	case "id":
+		where, args = " WHERE id = ?", []any{value}
+	default:
+		scimError(w, http.StatusBadRequest, "invalidFilter", "unsupported filter attribute "+attr)
+		return
+	}
+
+	var total int
+	db.QueryRow("SELECT COUNT(*) FROM users"+where, args...).Scan(&total)
+	start, count := scimPage(r)
+	rows, err := db.Query("SELECT id FROM users"+where+" ORDER BY id LIMIT ? OFFSET ?", append(args, count, start-1)...)
+	if err != nil {
+		scimError(w, http.StatusInternalServerError, "", "query failed")
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	users := []scimUser{}
+	for _, id := range ids {
+		if su, err := loadSCIMUser(id); err == nil {
+			users = append(users, *su)
+		}
+	}
+	scimList(w, total, start, len(users), users)
+}
+
+func handleSCIMGetUser(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	su, err := loadSCIMUser(id)
+	if err != nil {
+		scimError(w, http.StatusNotFound, "", "user not found")
+		return
+	}
+	writeSCIM(w, http.StatusOK, su)
+}
+
+// scimUserInput is the subset of the User schema the portal stores.
+type scimUserInput struct {
+	UserName    string      `json:"userName"`
+	ExternalID  string      `json:"externalId"`
+	DisplayName string      `json:"displayName"`
+	Name        scimName    `json:"name"`
+	Emails      []scimEmail `json:"emails"`
+	Active      *bool       `json:"active"`
+}
+
+func (in *scimUserInput) email() string {
+	email := in.UserName
+	if !strings.Contains(email, "@") {
+		for _, e := range in.Emails {
+			if e.Primary || email == "" || !strings.Contains(email, "@") {
+				email = e.Value
+			}
+		}
+	}
+	return strings.TrimSpace(strings.ToLower(email))
+}
+
+func (in *scimUserInput) displayName() string {
+	switch {
+	case strings.TrimSpace(in.DisplayName) != "":
+		return strings.TrimSpace(in.DisplayName)
+	case strings.TrimSpace(in.Name.Formatted) != "":
+		return strings.TrimSpace(in.Name.Formatted)
+	}
+	return strings.TrimSpace(in.Name.GivenName + " " + in.Name.FamilyName)
+}
+
+// scimManageable reports whether SCIM may change the user: only accounts it
+// provisioned itself that aren't admins, so the directory token can't take
+// over an account created in the portal.
+func scimManageable(id int64) bool {
+	var email string
+	err := db.QueryRow("SELECT email FROM users WHERE id = ? AND scim_provisioned = 1 AND role != 'admin'", id).Scan(&email)
+	return err == nil && !configAdminSet()[email]
+}
+
+func setSCIMActive(id int64, active bool) {
+	if active {
+		db.Exec("UPDATE users SET deactivated_at = NULL WHERE id = ?", id)
+	} else {
+		deactivateUser(id)
+	}
+}
+
+func handleSCIMCreateUser(w http.ResponseWriter, r *http.Request) {
+	var in scimUserInput
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&in); err != nil {
+		scimError(w, http.StatusBadRequest, "invalidSyntax", "invalid JSON body")
+		return
+	}
+	email := in.email()
+	if !strings.Contains(email, "@") {
+		scimError(w, http.StatusBadRequest, "invalidValue", "userName or a primary email is required")
+		return
+	}
+	var exists bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email = ?)", email).Scan(&exists)
+	if exists || configAdminSet()[email] {
+		scimError(w, http.StatusConflict, "uniqueness", "a user with this userName already exists")
+		return
+	}
+	name := in.displayName()
+	if name == "" {
+		name = strings.Split(email, "@")[0]
+	}
+	res, err := db.Exec("INSERT INTO users (email, name, role, scim_external_id, scim_provisioned) VALUES (?, ?, 'client', ?, 1)", email, name, in.ExternalID)
+	if err != nil {
+		scimError(w, http.StatusInternalServerError, "", "failed to create user")
+		return
+	}
+	id, _ := res.LastInsertId()
+	if in.Active != nil && !*in.Active {
+		setSCIMActive(id, false)
+	}
+	log.Printf("SCIM: created user %s", email)
+	su, _ := loadSCIMUser(id)
+	recordChange(r, nil, 0, "scim.user.create", email, nil, scimAuditUser(su))
+	writeSCIM(w, http.StatusCreated, su)
+}
+
+func handleSCIMReplaceUser(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	before, err := loadSCIMUser(id)
+	if err != nil {
+		scimError(w, http.StatusNotFound, "", "user not found")
+		return
+	}
+	if !scimManageable(id) {
+		scimError(w, http.StatusForbidden, "", "user is not managed by SCIM")
+		return
+	}
+	var in scimUserInput
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&in); err != nil {
+		scimError(w, http.StatusBadRequest, "invalidSyntax", "invalid JSON body")
+		return
+	}
+	if msg := applySCIMUser(id, &in); msg != "" {
+		scimError(w, http.StatusConflict, "uniqueness", msg)
+		return
+	}
+	su, _ := loadSCIMUser(id)
+	recordChange(r, nil, 0, "scim.user.update", su.UserName, scimAuditUser(before), scimAuditUser(su))
+	writeSCIM(w, http.StatusOK, su)
+}
+
+// applySCIMUser stores the attributes present in in. It returns a message when
+// the new userName belongs to someone else.
+func applySCIMUser(id int64, in *scimUserInput) string {
+	if email := in.email(); strings.Contains(email, "@") {
+		if configAdminSet()[email] {
+			return "a user with this userName already exists"
+		}
+		var other int64
+		if db.QueryRow("SELECT id FROM users WHERE email = ? AND id != ?", email, id).Scan(&other) == nil {
+			return "a user with this userName already exists"
+		}
+		db.Exec("UPDATE users SET email = ? WHERE id = ?", email, id)
+	}
+	if name := in.displayName(); name != "" {
+		db.Exec("UPDATE users SET name = ? WHERE id = ?", name, id)
+	}
+	if in.ExternalID != "" {
+		db.Exec("UPDATE users SET scim_external_id = ? WHERE id = ?", in.ExternalID, id)
+	}
+	if in.Active != nil {
+		setSCIMActive(id, *in.Active)
+	}
+	return ""
+}
+
+// scimPatchOp is one operation of a PatchOp request.
+type scimPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+func decodePatch(r *http.Request) ([]scimPatchOp, error) {
+	var req struct {
+		Operations []scimPatchOp `json:"Operations"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		return nil, err
+	}
+	return req.Operations, nil
+}
+
+// scimBool accepts both JSON booleans and the "True"/"False" strings some
+// directories send.
+func scimBool(raw json.RawMessage) (bool, bool) {
+	var b bool
+	if json.Unmarshal(raw, &b) == nil {
+		return b, true
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		if v, err := strconv.ParseBool(s); err == nil {
+			return v, true
+		}
+	}
+	return false, false
+}
+
+func handleSCIMPatchUser(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	before, err := loadSCIMUser(id)
+	if err != nil {
+		scimError(w, http.StatusNotFound, "", "user not found")
+		return
+	}
+	if !scimManageable(id) {
+		scimError(w, http.StatusForbidden, "", "user is not managed by SCIM")
+		return
+	}
+	ops, err := decodePatch(r)
+	if err != nil {
+		scimError(w, http.StatusBadRequest, "invalidSyntax", "invalid PatchOp body")
+		return
+	}
+	for _, op := range ops {
+		if !strings.EqualFold(op.Op, "replace") && !strings.EqualFold(op.Op, "add") {
+			continue
+		}
+		var in scimUserInput
+		switch strings.ToLower(op.Path) {
+		case "":
+			json.Unmarshal(op.Value, &in)
+			// Some directories send the value object with dotted keys
+			var flat map[string]json.RawMessage
+			if json.Unmarshal(op.Value, &flat) == nil {
+				if v, ok := flat["name.formatted"]; ok {
+					json.Unmarshal(v, &in.Name.Formatted)
+				}
+				if v, ok := flat["active"]; ok {
+					if b, ok := scimBool(v); ok {
+						in.Active = &b
+					}
+				}
+			}
+		case "active":
+			b, ok := scimBool(op.Value)
+			if !ok {
+				scimError(w, http.StatusBadRequest, "invalidValue", "active must be a boolean")
+				return
+			}
+			in.Active = &b
+		case "username":
+			json.Unmarshal(op.Value, &in.UserName)
+		case "displayname":
+			json.Unmarshal(op.Value, &in.DisplayName)
+		case "name.formatted":
+			json.Unmarshal(op.Value, &in.Name.Formatted)
+		case "externalid":
+			json.Unmarshal(op.Value, &in.ExternalID)
+		case `emails[type eq "work"].value`:
+			var e string
+			json.Unmarshal(op.Value, &e)
+			in.Emails = []scimEmail{{Value: e, Primary: true}}
+		default:
+			// Attributes the portal doesn't store are accepted and ignored
+			continue
+		}
+		if msg := applySCIMUser(id, &in); msg != "" {
+			scimError(w, http.StatusConflict, "uniqueness", msg)
+			return
+		}
+	}
+	su, _ := loadSCIMUser(id)
+	recordChange(r, nil, 0, "scim.user.update", su.UserName, scimAuditUser(before), scimAuditUser(su))
+	writeSCIM(w, http.StatusOK, su)
+}
+
+func handleSCIMDeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	before, err := loadSCIMUser(id)
+	if err != nil {
+		scimError(w, http.StatusNotFound, "", "user not found")
+		return
+	}
+	if !scimManageable(id) {
+		scimError(w, http.StatusForbidden, "", "user is not managed by SCIM")
+		return
+	}
+	setSCIMActive(id, false)
+	recordChange(r, nil, 0, "scim.user.deactivate", before.UserName, scimAuditUser(before), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Groups
+
+func loadSCIMGroup(id int64, withMembers bool) (*scimGroup, error) {
+	var sg scimGroup
+	var gid int64
+	err := db.QueryRow("SELECT id, display_name, external_id, created_at, updated_at FROM scim_groups WHERE id = ?", id).
+		Scan(&gid, &sg.DisplayName, &sg.ExternalID, &sg.Meta.Created, &sg.Meta.LastModified)
+	if err != nil {
+		return nil, err
+	}
+	sg.Schemas = []string{scimGroupSchema}
+	sg.ID = strconv.FormatInt(gid, 10)
+	sg.Meta.ResourceType = "Group"
+	sg.Meta.Location = cfg.BaseURL + "/scim/v2/Groups/" + sg.ID
+	if !withMembers {
+		return &sg, nil
+	}
+	sg.Members = []scimRef{}
+	rows, err := db.Query(`SELECT u.id, u.email FROM scim_group_members gm JOIN users u ON u.id = gm.user_id
+		WHERE gm.group_id = ? ORDER BY u.id`, gid)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var uid int64
+			var ref scimRef
+			rows.Scan(&uid, &ref.Display)
+			ref.Value = strconv.FormatInt(uid, 10)
+			ref.Ref = cfg.BaseURL + "/scim/v2/Users/" + ref.Value
+			sg.Members = append(sg.Members, ref)
+		}
+	}
+	return &sg, nil
+}
+
+func handleSCIMListGroups(w http.ResponseWriter, r *http.Request) {
+	attr, value, ok := scimFilter(r)
+	if !ok {
+		scimError(w, http.StatusBadRequest, "invalidFilter", "only `attribute eq \"value\"` filters are supported")
+		return
+	}
+	where := ""
+	var args []any
+	switch attr {
+	case "":
+	case "displayname":
+		where, args = " WHERE display_name = ?", []any{value}
+	case "externalid":
+		where, args = " WHERE external_id = ?", []any{value}
+	case "id":
+		where, args = " WHERE id = ?", []any{value}
+	default:
+		scimError(w, http.StatusBadRequest, "invalidFilter", "unsupported filter attribute "+attr)
+		return
+	}
+	withMembers := !strings.Contains(r.URL.Query().Get("excludedAttributes"), "members")
+
+	var total int
+	db.QueryRow("SELECT COUNT(*) FROM scim_groups"+where, args...).Scan(&total)
+	start, count := scimPage(r)
+	rows, err := db.Query("SELECT id FROM scim_groups"+where+" ORDER BY id LIMIT ? OFFSET ?", append(args, count, start-1)...)
+	if err != nil {
+		scimError(w, http.StatusInternalServerError, "", "query failed")
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	groups := []scimGroup{}
+	for _, id := range ids {
+		if sg, err := loadSCIMGroup(id, withMembers); err == nil {
+			groups = append(groups, *sg)
+		}
+	}
+	scimList(w, total, start, len(groups), groups)
+}
+
+func handleSCIMGetGroup(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	sg, err := loadSCIMGroup(id, !strings.Contains(r.URL.Query().Get("excludedAttributes"), "members"))
+	if err != nil {
+		scimError(w, http.StatusNotFound, "", "group not found")
+		return
+	}
+	writeSCIM(w, http.StatusOK, sg)
+}
+
+type scimGroupInput struct {
+	DisplayName string    `json:"displayName"`
+	ExternalID  string    `json:"externalId"`
+	Members     []scimRef `json:"members"`
+}
+
+func handleSCIMCreateGroup(w http.ResponseWriter, r *http.Request) {
+	var in scimGroupInput
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&in); err != nil {
+		scimError(w, http.StatusBadRequest, "invalidSyntax", "invalid JSON body")
+		return
+	}
+	name := strings.TrimSpace(in.DisplayName)
+	if name == "" {
+		scimError(w, http.StatusBadRequest, "invalidValue", "displayName is required")
+		return
+	}
+	var exists bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM scim_groups WHERE display_name = ?)", name).Scan(&exists)
+	if exists {
+		scimError(w, http.StatusConflict, "uniqueness", "a group with this displayName already exists")
+		return
+	}
+	res, err := db.Exec("INSERT INTO scim_groups (display_name, external_id) VALUES (?, ?)", name, in.ExternalID)
+	if err != nil {
+		scimError(w, http.StatusInternalServerError, "", "failed to create group")
+		return
+	}
+	id, _ := res.LastInsertId()
+	setSCIMGroupMembers(id, in.Members, true)
+	log.Printf("SCIM: created group %s", name)
+	sg, _ := loadSCIMGroup(id, true)
+	recordChange(r, nil, 0, "scim.group.create", name, nil, scimAuditGroup(sg))
+	writeSCIM(w, http.StatusCreated, sg)
+}
+
+func handleSCIMReplaceGroup(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	before, err := loadSCIMGroup(id, true)
+	if err != nil {
+		scimError(w, http.StatusNotFound, "", "group not found")
+		return
+	}
+	var in scimGroupInput
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&in); err != nil {
+		scimError(w, http.StatusBadRequest, "invalidSyntax", "invalid JSON body")
+		return
+	}
+	if name := strings.TrimSpace(in.DisplayName); name != "" {
+		db.Exec("UPDATE scim_groups SET display_name = ? WHERE id = ?", name, id)
+	}
+	if in.ExternalID != "" {
+		db.Exec("UPDATE scim_groups SET external_id = ? WHERE id = ?", in.ExternalID, id)
+	}
+	setSCIMGroupMembers(id, in.Members, true)
+	sg, _ := loadSCIMGroup(id, true)
+	recordChange(r, nil, 0, "scim.group.update", sg.DisplayName, scimAuditGroup(before), scimAuditGroup(sg))
+	writeSCIM(w, http.StatusOK, sg)
+}
+
+func handleSCIMPatchGroup(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	before, err := loadSCIMGroup(id, true)
+	if err != nil {
+		scimError(w, http.StatusNotFound, "", "group not found")
+		return
+	}
+	ops, err := decodePatch(r)
+	if err != nil {
+		scimError(w, http.StatusBadRequest, "invalidSyntax", "invalid PatchOp body")
+		return
+	}
+	for _, op := range ops {
+		path := strings.ToLower(strings.TrimSpace(op.Path))
+		var members []scimRef
+		switch {
+		case path == "" && strings.EqualFold(op.Op, "replace"):
+			var in scimGroupInput
+			json.Unmarshal(op.Value, &in)
+			if name := strings.TrimSpace(in.DisplayName); name != "" {
+				db.Exec("UPDATE scim_groups SET display_name = ? WHERE id = ?", name, id)
+			}
+			if in.Members != nil {
+				setSCIMGroupMembers(id, in.Members, true)
+			}
+		case path == "displayname":
+			var name string
+			json.Unmarshal(op.Value, &name)
+			if name = strings.TrimSpace(name); name != "" {
+				db.Exec("UPDATE scim_groups SET display_name = ? WHERE id = ?", name, id)
+			}
+		case path == "members" || path == "":
+			json.Unmarshal(op.Value, &members)
+			switch strings.ToLower(op.Op) {
+			case "add":
+				setSCIMGroupMembers(id, members, false)
+			case "replace":
+				setSCIMGroupMembers(id, members, true)
+			case "remove":
+				if len(members) == 0 {
+					setSCIMGroupMembers(id, nil, true)
+				} else {
+					removeSCIMGroupMembers(id, members)
+				}
+			}
+		case strings.HasPrefix(path, "members[value eq ") && strings.EqualFold(op.Op, "remove"):
+			// members[value eq "12"]
+			v := strings.TrimSuffix(strings.TrimSpace(op.Path[len("members[value eq "):]), "]")
+			if uq, err := strconv.Unquote(v); err == nil {
+				removeSCIMGroupMembers(id, []scimRef{{Value: uq}})
+			}
+		}
+	}
+	db.Exec("UPDATE scim_groups SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	sg, _ := loadSCIMGroup(id, true)
+	recordChange(r, nil, 0, "scim.group.update", sg.DisplayName, scimAuditGroup(before), scimAuditGroup(sg))
+	writeSCIM(w, http.StatusOK, sg)
+}
+
+func handleSCIMDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	before, err := loadSCIMGroup(id, true)
+	if err != nil {
+		scimError(w, http.StatusNotFound, "", "group not found")
+		return
+	}
+	affected := scimGroupMemberIDs(id)
+	db.Exec("DELETE FROM scim_groups WHERE id = ?", id)
+	for _, uid := range affected {
+		syncSCIMMemberships(uid)
+	}
+	recordChange(r, nil, 0, "scim.group.delete", before.DisplayName, scimAuditGroup(before), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// scimAuditUser and scimAuditGroup pick the attributes worth keeping in the
+// audit log for directory changes.
+func scimAuditUser(su *scimUser) map[string]any {
+	return map[string]any{"userName": su.UserName, "displayName": su.DisplayName, "active": su.Active}
+}
+
+func scimAuditGroup(sg *scimGroup) map[string]any {
+	members := []string{}
+	for _, m := range sg.Members {
+		members = append(members, m.Display)
+	}
+	return map[string]any{"displayName": sg.DisplayName, "members": members}
+}
+
+func scimGroupMemberIDs(groupID int64) []int64 {
+	rows, err := db.Query("SELECT user_id FROM scim_group_members WHERE group_id = ?", groupID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// setSCIMGroupMembers adds members to the group, or replaces the whole list
+// when replace is set, then re-syncs everyone affected.
+func setSCIMGroupMembers(groupID int64, members []scimRef, replace bool) {
+	affected := map[int64]bool{}
+	if replace {
+		for _, uid := range scimGroupMemberIDs(groupID) {
+			if scimManageable(uid) {
+				db.Exec("DELETE FROM scim_group_members WHERE group_id = ? AND user_id = ?", groupID, uid)
+				affected[uid] = true
+			}
+		}
+	}
+	for _, m := range members {
+		uid, err := strconv.ParseInt(m.Value, 10, 64)
+		if err != nil || !scimManageable(uid) {
+			continue
+		}
+		if _, err := db.Exec("INSERT OR IGNORE INTO scim_group_members (group_id, user_id) VALUES (?, ?)", groupID, uid); err == nil {
+			affected[uid] = true
+		}
+	}
+	for uid := range affected {
+		syncSCIMMemberships(uid)
+	}
+}
+
+func removeSCIMGroupMembers(groupID int64, members []scimRef) {
+	for _, m := range members {
+		uid, err := strconv.ParseInt(m.Value, 10, 64)
+		if err != nil || !scimManageable(uid) {
+			continue
+		}
+		db.Exec("DELETE FROM scim_group_members WHERE group_id = ? AND user_id = ?", groupID, uid)
+		syncSCIMMemberships(uid)
+	}
+}
+
+var scimRoleRank = map[string]int{"client": 1, "member": 2, "owner": 3}
+
+// syncSCIMMemberships makes the user's SCIM-managed project memberships match
+// the highest role granted by their mapped groups. Memberships added by hand
+// in project settings are never touched.
+func syncSCIMMemberships(userID int64) {
+	desired := map[int64]string{}
+	rows, err := db.Query(`SELECT g.project_id, g.role FROM scim_group_members gm
+		JOIN scim_groups g ON g.id = gm.group_id
+		WHERE gm.user_id = ? AND g.project_id IS NOT NULL`, userID)
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		var pid int64
+		var role string
+		rows.Scan(&pid, &role)
+		if scimRoleRank[role] > scimRoleRank[desired[pid]] {
+			desired[pid] = role
+		}
+	}
+	rows.Close()
+
+	managed := map[int64]bool{}
+	rows, err = db.Query("SELECT project_id, scim_managed FROM project_members WHERE user_id = ?", userID)
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		var pid int64
+		var m bool
+		rows.Scan(&pid, &m)
+		managed[pid] = m
+	}
+	rows.Close()
+
+	for pid, role := range desired {
+		if m, exists := managed[pid]; exists && !m {
+			continue
+		}
+		db.Exec(`INSERT INTO project_members (project_id, user_id, role, scim_managed) VALUES (?, ?, ?, 1)
+			ON CONFLICT(project_id, user_id) DO UPDATE SET role = excluded.role`, pid, userID, role)
+	}
+	for pid, m := range managed {
+		if _, ok := desired[pid]; m && !ok {
+			db.Exec("DELETE FROM project_members WHERE project_id = ? AND user_id = ? AND scim_managed = 1", pid, userID)
+		}
+	}
+}
+
+// Admin console
+
+func allSCIMGroups() []SCIMGroup {
+	rows, err := db.Query(`SELECT g.id, g.display_name, g.project_id, g.role,
+			(SELECT COUNT(*) FROM scim_group_members WHERE group_id = g.id)
+		FROM scim_groups g ORDER BY g.display_name`)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var groups []SCIMGroup
+	for rows.Next() {
+		var g SCIMGroup
+		rows.Scan(&g.ID, &g.DisplayName, &g.ProjectID, &g.Role, &g.MemberCount)
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+func handleAdminSCIM(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u.Role != "admin" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Method == "POST" {
+		groupID, _ := strconv.ParseInt(r.FormValue("group_id"), 10, 64)
+		var projectID *int64
+		if pid, _ := strconv.ParseInt(r.FormValue("project_id"), 10, 64); pid > 0 {
+			projectID = &pid
+		}
+		role := r.FormValue("role")
+		if _, ok := scimRoleRank[role]; !ok {
+			role = "client"
+		}
+		var name string
+		if db.QueryRow("SELECT display_name FROM scim_groups WHERE id = ?", groupID).Scan(&name) == sql.ErrNoRows {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		db.Exec("UPDATE scim_groups SET project_id = ?, role = ? WHERE id = ?", projectID, role, groupID)
+		for _, uid := range scimGroupMemberIDs(groupID) {
+			syncSCIMMemberships(uid)
+		}
+		recordAudit(r, u, "scim_group.map", fmt.Sprintf("%s → %s", name, role))
+		http.Redirect(w, r, "/admin/scim", http.StatusSeeOther)
+		return
+	}
+	renderTemplate(w, "admin_scim.html", map[string]any{
+		"User":      u,
+		"Projects":  userProjects(u),
+		"Groups":    allSCIMGroups(),
+		"Roles":     []string{"client", "member", "owner"},
+		"Enabled":   cfg.SCIMToken != "",
+		"BaseURL":   cfg.BaseURL + "/scim/v2",
+		"AdminPage": "scim",
+	})
+}
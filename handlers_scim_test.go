@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scimRequest sends method and body to the SCIM Users endpoints through
+// scimAuth, as the directory would.
+func scimRequest(t *testing.T, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /Users", handleSCIMCreateUser)
+	mux.HandleFunc("PUT /Users/{id}", handleSCIMReplaceUser)
+	mux.HandleFunc("PATCH /Users/{id}", handleSCIMPatchUser)
+	mux.HandleFunc("DELETE /Users/{id}", handleSCIMDeleteUser)
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer scim-secret")
+	rec := httptest.NewRecorder()
+	scimAuth(mux).ServeHTTP(rec, req)
+	return rec
+}
+
+func setupSCIM(t *testing.T) {
+	t.Helper()
+	openTestDB(t)
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg.SCIMToken = "scim-secret"
+	cfg.AdminEmails = []string{"root@example.com"}
+}
+
+func TestSCIMAuth(t *testing.T) {
+	setupSCIM(t)
+	req := httptest.NewRequest("DELETE", "/Users/1", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec := httptest.NewRecorder()
+	scimAuth(http.NotFoundHandler()).ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: %d, want 401", rec.Code)
+	}
+	cfg.SCIMToken = ""
+	if rec := scimRequest(t, "DELETE", "/Users/1", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("SCIM disabled: %d, want 404", rec.Code)
+	}
+}
+
+func TestSCIMOnlyChangesProvisionedUsers(t *testing.T) {
+	setupSCIM(t)
+	mustExec(t, `INSERT INTO users (id, email, name, role, scim_provisioned) VALUES
+		(1, 'admin@example.com', 'Admin', 'admin', 0),
+		(2, 'local@example.com', 'Local', 'user', 0),
+		(3, 'admin2@example.com', 'Admin 2', 'admin', 1),
+		(4, 'root@example.com', 'Root', 'user', 1)`)
+	deactivated := func(id string) bool {
+		var off bool
+		db.QueryRow("SELECT deactivated_at IS NOT NULL FROM users WHERE id = ?", id).Scan(&off)
+		return off
+	}
+
+	for _, id := range []string{"1", "2", "3", "4"} {
+		patch := `{"Operations":[{"op":"replace","path":"userName","value":"taken` + id + `@example.com"}]}`
+		if rec := scimRequest(t, "PATCH", "/Users/"+id, patch); rec.Code != http.StatusForbidden {
+			t.Errorf("PATCH user %s: %d, want 403", id, rec.Code)
+		}
+		if rec := scimRequest(t, "PUT", "/Users/"+id, `{"userName":"x`+id+`@example.com"}`); rec.Code != http.StatusForbidden {
+			t.Errorf("PUT user %s: %d, want 403", id, rec.Code)
+		}
+		if rec := scimRequest(t, "DELETE", "/Users/"+id, ""); rec.Code != http.StatusForbidden || deactivated(id) {
+			t.Errorf("DELETE user %s: %d, deactivated %v", id, rec.Code, deactivated(id))
+		}
+	}
+	var email string
+	db.QueryRow("SELECT email FROM users WHERE id = 1").Scan(&email)
+	if email != "admin@example.com" {
+		t.Errorf("admin email changed to %s", email)
+	}
+
+	if rec := scimRequest(t, "POST", "/Users", `{"userName":"root@example.com"}`); rec.Code != http.StatusConflict {
+		t.Errorf("creating a config admin: %d, want 409", rec.Code)
+	}
+	rec := scimRequest(t, "POST", "/Users", `{"userName":"Ana@Example.com","displayName":"Ana"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body)
+	}
+	var id string
+	db.QueryRow("SELECT id FROM users WHERE email = 'ana@example.com' AND scim_provisioned = 1 AND role = 'client'").Scan(&id)
+	if id == "" {
+		t.Fatal("provisioned user not stored as a client")
+	}
+	if rec := scimRequest(t, "PATCH", "/Users/"+id, `{"Operations":[{"op":"replace","path":"userName","value":"local@example.com"}]}`); rec.Code != http.StatusConflict {
+		t.Errorf("renaming onto another account: %d, want 409", rec.Code)
+	}
+	if rec := scimRequest(t, "PATCH", "/Users/"+id, `{"Operations":[{"op":"replace","path":"active","value":"False"}]}`); rec.Code != http.StatusOK || !deactivated(id) {
+		t.Errorf("deactivating a provisioned user: %d, deactivated %v", rec.Code, deactivated(id))
+	}
+	if rec := scimRequest(t, "DELETE", "/Users/"+id, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("deleting a provisioned user: %d", rec.Code)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func acceptInvitation(token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/invite/"+token, strings.NewReader(url.Values{"action": {"accept"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetPathValue("token", token)
+	rec := httptest.NewRecorder()
+	handleInvitation(rec, req)
+	return rec
+}
+
+func TestInvitationKeepsExistingMembership(t *testing.T) {
+	openTestDB(t)
+	initTemplates()
+	seedProject(t)
+	mustExec(t,
+		"UPDATE project_members SET scim_managed = 1 WHERE user_id = 1",
+		`INSERT INTO invitations (project_id, email, role, token, expires_at) VALUES
+			(1, 'owner@example.com', 'client', 'tok-owner', datetime('now', '+1 day')),
+			(1, 'new@example.com', 'member', 'tok-new', datetime('now', '+1 day'))`,
+	)
+
+	acceptInvitation("tok-owner")
+	var role string
+	var managed bool
+	db.QueryRow("SELECT role, scim_managed FROM project_members WHERE project_id = 1 AND user_id = 1").Scan(&role, &managed)
+	if role != "owner" || !managed {
+		t.Errorf("owner accepting a client invite: role %q, scim_managed %v", role, managed)
+	}
+
+	acceptInvitation("tok-new")
+	db.QueryRow("SELECT role FROM project_members WHERE project_id = 1 AND user_id = 6").Scan(&role)
+	if role != "member" {
+		t.Errorf("new member joined as %q, want member", role)
+	}
+}
+
+func TestInvitationShowsRoleLabel(t *testing.T) {
+	openTestDB(t)
+	initTemplates()
+	seedProject(t)
+	mustExec(t, `INSERT INTO invitations (project_id, email, role, token, expires_at)
+		VALUES (1, 'new@example.com', 'owner', 'tok', datetime('now', '+1 day'))`)
+	req := httptest.NewRequest("GET", "/invite/tok", nil)
+	req.SetPathValue("token", "tok")
+	rec := httptest.NewRecorder()
+	handleInvitation(rec, req)
+	if body := rec.Body.String(); !strings.Contains(body, ">Propietario</span>") {
+		t.Errorf("invite page does not show the role label:\n%s", body)
+	}
+}
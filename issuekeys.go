@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Issue keys read like ACME-42: the project's prefix and the issue's number
+// within the project. Prefixes are unique across projects so a key alone
+// names one issue.
+var (
+	issuePrefixRe  = regexp.MustCompile(`^[A-Z][A-Z0-9]{1,9}$`)
+	issueKeyRe     = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9]{1,9})-([0-9]+)$`)
+	issueKeyTextRe = regexp.MustCompile(`\b([A-Z][A-Z0-9]{1,9})-([0-9]+)\b`)
+)
+
+// uniqueIssuePrefix derives a free prefix from a project slug: its letters
+// and digits in upper case, up to six, with a number added on collision.
+func uniqueIssuePrefix(slug string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(slug) {
+		if (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9' && b.Len() > 0) {
+			b.WriteRune(c)
+		}
+		if b.Len() == 6 {
+			break
+		}
+	}
+	base := b.String()
+	if len(base) < 2 {
+		base = "P" + base + "X"
+	}
+	prefix := base
+	for n := 2; ; n++ {
+		var taken bool
+		db.QueryRow("SELECT EXISTS(SELECT 1 FROM projects WHERE issue_prefix = ?)", prefix).Scan(&taken)
+		if !taken {
+			return prefix
+		}
+		prefix = base + strconv.Itoa(n)
+	}
+}
+
+// nextIssueNumber takes the next number of the project's sequence. Numbers
+// of deleted issues are not reused.
+func nextIssueNumber(projectID int64) int64 {
+	var n int64
+	db.QueryRow("UPDATE projects SET issue_seq = issue_seq + 1 WHERE id = ? RETURNING issue_seq", projectID).Scan(&n)
+	return n
+}
+
+func issueKey(prefix string, number int64) string {
+	return fmt.Sprintf("%s-%d", prefix, number)
+}
+
+// resolveIssueID turns the {id} of an issue URL into the issue id. It takes
+// the key of an issue of the project, in any case. Links from before keys
+// used the internal id, which still resolves as a legacy fallback, but only
+// for issues of the same project. It returns 0 when nothing matches.
+func resolveIssueID(projectID int64, ref string) int64 {
+	var id int64
+	m := issueKeyRe.FindStringSubmatch(ref)
+	if m == nil {
+		db.QueryRow("SELECT id FROM issues WHERE id = ? AND project_id = ?", ref, projectID).Scan(&id)
+		return id
+	}
+	db.QueryRow(`SELECT i.id FROM issues i JOIN projects p ON p.id = i.project_id
+		WHERE i.project_id = ? AND p.issue_prefix = ? AND i.number = ?`, projectID, strings.ToUpper(m[1]), m[2]).Scan(&id)
+	return id
+}
+
+// issuePath is the URL of an issue page, by key.
+func issuePath(p *Project, issueID int64) string {
+	var number int64
+	db.QueryRow("SELECT number FROM issues WHERE id = ? AND project_id = ?", issueID, p.ID).Scan(&number)
+	if number == 0 {
+		return "/projects/" + p.Slug + "/issues/" + strconv.FormatInt(issueID, 10)
+	}
+	return "/projects/" + p.Slug + "/issues/" + issueKey(p.IssuePrefix, number)
+}
+
+// linkIssueKeys escapes chat text and links the keys of the project's
+// issues. Keys of other projects, or of issues that don't exist, stay text.
+func linkIssueKeys(text string, p *Project) template.HTML {
+	var b strings.Builder
+	last := 0
+	for _, m := range issueKeyTextRe.FindAllStringSubmatchIndex(text, -1) {
+		if p == nil || text[m[2]:m[3]] != p.IssuePrefix {
+			continue
+		}
+		var exists bool
+		db.QueryRow("SELECT EXISTS(SELECT 1 FROM issues WHERE project_id = ? AND number = ?)", p.ID, text[m[4]:m[5]]).Scan(&exists)
+		if !exists {
+			continue
+		}
+		key := text[m[0]:m[1]]
+		b.WriteString(template.HTMLEscapeString(text[last:m[0]]))
+		fmt.Fprintf(&b, `<a href="/projects/%s/issues/%s" class="issue-key">%s</a>`, template.HTMLEscapeString(p.Slug), key, key)
+		last = m[1]
+	}
+	b.WriteString(template.HTMLEscapeString(text[last:]))
+	return template.HTML(b.String())
+}
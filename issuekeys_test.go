@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// seedIssueKeys adds a second project, BETA, next to acme, with issues
+// whose internal ids don't match their numbers.
+func seedIssueKeys(t *testing.T) {
+	t.Helper()
+	seedProject(t)
+	mustExec(t,
+		"INSERT INTO projects (id, name, slug, issue_prefix) VALUES (2, 'Beta', 'beta', 'BETA')",
+		`INSERT INTO issues (id, project_id, number, title, status) VALUES
+			(10, 1, 1, 'A', 'todo'), (11, 2, 1, 'B', 'todo'), (12, 1, 2, 'C', 'todo')`,
+	)
+}
+
+func TestResolveIssueID(t *testing.T) {
+	openTestDB(t)
+	seedIssueKeys(t)
+	tests := []struct {
+		project int64
+		ref     string
+		want    int64
+	}{
+		{1, "ACME-1", 10},
+		{1, "acme-2", 12},
+		{2, "Beta-1", 11},
+		{1, "BETA-1", 0},
+		{1, "ACME-3", 0},
+		{1, "10", 10},
+		{1, "11", 0},
+		{2, "11", 11},
+		{1, "1", 0},
+		{1, "ACME-1x", 0},
+		{1, "", 0},
+	}
+	for _, tt := range tests {
+		if got := resolveIssueID(tt.project, tt.ref); got != tt.want {
+			t.Errorf("resolveIssueID(%d, %q) = %d, want %d", tt.project, tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestUniqueIssuePrefix(t *testing.T) {
+	openTestDB(t)
+	seedIssueKeys(t)
+	for slug, want := range map[string]string{
+		"acme":             "ACME2",
+		"new-client":       "NEWCLI",
+		"9lives":           "LIVES",
+		"x":                "PXX",
+		"beta":             "BETA2",
+		"web-2026-rebuild": "WEB202",
+	} {
+		if got := uniqueIssuePrefix(slug); got != want {
+			t.Errorf("uniqueIssuePrefix(%q) = %q, want %q", slug, got, want)
+		}
+	}
+}
+
+func TestLinkIssueKeys(t *testing.T) {
+	openTestDB(t)
+	seedIssueKeys(t)
+	p := &Project{ID: 1, Slug: "acme", IssuePrefix: "ACME"}
+	got := string(linkIssueKeys("ver ACME-1, BETA-1, ACME-9 y <b>ACME-2</b>", p))
+	want := `ver <a href="/projects/acme/issues/ACME-1" class="issue-key">ACME-1</a>, BETA-1, ACME-9 y ` +
+		`&lt;b&gt;<a href="/projects/acme/issues/ACME-2" class="issue-key">ACME-2</a>&lt;/b&gt;`
+	if got != want {
+		t.Errorf("linkIssueKeys\n got %s\nwant %s", got, want)
+	}
+	if got := string(linkIssueKeys("ACME-1 <i>", nil)); strings.Contains(got, "<a") || strings.Contains(got, "<i>") {
+		t.Errorf("without a project: %s", got)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var labelColorRe = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// defaultLabelColor is used when the form sends no valid colour.
+const defaultLabelColor = "#6b7280"
+
+func labelColor(s string) string {
+	if labelColorRe.MatchString(s) {
+		return strings.ToLower(s)
+	}
+	return defaultLabelColor
+}
+
+// projectLabels lists the labels of a project by name, with how many issues
+// use each.
+func projectLabels(projectID int64) []Label {
+	rows, err := db.Query(`
+		SELECT l.id, l.project_id, l.name, l.color, COUNT(il.issue_id)
+		FROM labels l
+		LEFT JOIN issue_labels il ON il.label_id = l.id
+		WHERE l.project_id = ?
+		GROUP BY l.id
+		ORDER BY l.name COLLATE NOCASE`, projectID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var labels []Label
+	for rows.Next() {
+		var l Label
+		rows.Scan(&l.ID, &l.ProjectID, &l.Name, &l.Color, &l.Issues)
+		labels = append(labels, l)
+	}
+	return labels
+}
+
+// attachLabels fills in the labels of issues, which all belong to one project.
+func attachLabels(issues []Issue) {
+	if len(issues) == 0 {
+		return
+	}
+	rows, err := db.Query(`
+		SELECT il.issue_id, l.id, l.project_id, l.name, l.color
+		FROM issue_labels il
+		JOIN labels l ON l.id = il.label_id
+		WHERE l.project_id = ?
+		ORDER BY l.name COLLATE NOCASE`, issues[0].ProjectID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	byIssue := map[int64][]Label{}
+	for rows.Next() {
+		var issueID int64
+		var l Label
+		rows.Scan(&issueID, &l.ID, &l.ProjectID, &l.Name, &l.Color)
+		byIssue[issueID] = append(byIssue[issueID], l)
+	}
+	for i := range issues {
+		issues[i].Labels = byIssue[issues[i].ID]
+	}
+}
+
+// issueLabelNames is the label set of an issue as recorded in its history:
+// names in order, comma separated, or nil for none.
+func issueLabelNames(issueID int64) *string {
+	var names *string
+	db.QueryRow(`SELECT GROUP_CONCAT(name, ', ') FROM (
+		SELECT l.name FROM issue_labels il JOIN labels l ON l.id = il.label_id
+		WHERE il.issue_id = ? ORDER BY l.name COLLATE NOCASE)`, issueID).Scan(&names)
+	return names
+}
+
+// setIssueLabels replaces the labels of an issue. Ids of labels from other
+// projects are ignored.
+func setIssueLabels(projectID, issueID int64, labelIDs []int64) {
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	tx.Exec("DELETE FROM issue_labels WHERE issue_id = ?", issueID)
+	for _, id := range labelIDs {
+		tx.Exec(`INSERT OR IGNORE INTO issue_labels (issue_id, label_id)
+			SELECT ?, id FROM labels WHERE id = ? AND project_id = ?`, issueID, id, projectID)
+	}
+	tx.Commit()
+}
+
+// labelIDsByName resolves label names, ignoring case, for the API. It
+// returns the first name that doesn't exist in the project.
+func labelIDsByName(projectID int64, names []string) ([]int64, string) {
+	var ids []int64
+	for _, name := range names {
+		var id int64
+		err := db.QueryRow("SELECT id FROM labels WHERE project_id = ? AND name = ? COLLATE NOCASE",
+			projectID, strings.TrimSpace(name)).Scan(&id)
+		if err != nil {
+			return nil, name
+		}
+		ids = append(ids, id)
+	}
+	return ids, ""
+}
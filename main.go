@@ -21,6 +21,7 @@ func main() {
 	loadConfig(configPath)
 	initDB(cfg.DBPath)
 	initTemplates()
+	initWebAuthn()
 	os.MkdirAll(cfg.UploadDir, 0755)
 	os.MkdirAll(cfg.DashboardDir, 0755)
 
@@ -53,30 +54,93 @@ func main() {
 	mux.HandleFunc("GET /auth/approve", handleApprove)
 	mux.HandleFunc("POST /auth/approve", handleApprove)
 	mux.HandleFunc("GET /auth/status", handleAuthStatus)
+	mux.HandleFunc("POST /auth/code", handleVerifyCode)
+	mux.HandleFunc("GET /auth/totp", handleTOTP)
+	mux.HandleFunc("POST /auth/totp", handleTOTP)
+	mux.HandleFunc("GET /auth/oidc/login", handleOIDCLogin)
+	mux.HandleFunc("GET /auth/oidc/callback", handleOIDCCallback)
+	mux.HandleFunc("POST /auth/passkey/begin", handlePasskeyLoginBegin)
+	mux.HandleFunc("POST /auth/passkey/finish", handlePasskeyLoginFinish)
+	mux.HandleFunc("GET /invite/{token}", handleInvitation)
+	mux.HandleFunc("POST /invite/{token}", handleInvitation)
 	mux.HandleFunc("POST /logout", handleLogout)
 	mux.HandleFunc("GET /llms.txt", handleLlmsTxt)
 
 	// API routes (API key auth)
 	api := http.NewServeMux()
+	api.HandleFunc("GET /projects", handleAPIListProjects)
+	api.HandleFunc("GET /search", handleAPISearch)
 	api.HandleFunc("PUT /projects/{slug}/dashboard/{path...}", handleAPIPushDashboard)
 	api.HandleFunc("PUT /projects/{slug}/status", handleAPIPushStatus)
 	api.HandleFunc("PUT /projects/{slug}/roadmap", handleAPIPushRoadmap)
 	api.HandleFunc("POST /projects/{slug}/issues", handleAPICreateIssue)
+	api.HandleFunc("GET /projects/{slug}/issues/{id}", handleAPIGetIssue)
+	api.HandleFunc("POST /projects/{slug}/issues/{id}/relations", handleAPIAddRelation)
+	api.HandleFunc("DELETE /projects/{slug}/issues/{id}/relations/{rid}", handleAPIDeleteRelation)
 	mux.Handle("/api/", http.StripPrefix("/api", apiKeyAuth(api)))
 
+	// SCIM 2.0 provisioning (dedicated bearer token)
+	scim := http.NewServeMux()
+	scim.HandleFunc("GET /ServiceProviderConfig", handleSCIMServiceProviderConfig)
+	scim.HandleFunc("GET /ResourceTypes", handleSCIMResourceTypes)
+	scim.HandleFunc("GET /Users", handleSCIMListUsers)
+	scim.HandleFunc("POST /Users", handleSCIMCreateUser)
+	scim.HandleFunc("GET /Users/{id}", handleSCIMGetUser)
+	scim.HandleFunc("PUT /Users/{id}", handleSCIMReplaceUser)
+	scim.HandleFunc("PATCH /Users/{id}", handleSCIMPatchUser)
+	scim.HandleFunc("DELETE /Users/{id}", handleSCIMDeleteUser)
+	scim.HandleFunc("GET /Groups", handleSCIMListGroups)
+	scim.HandleFunc("POST /Groups", handleSCIMCreateGroup)
+	scim.HandleFunc("GET /Groups/{id}", handleSCIMGetGroup)
+	scim.HandleFunc("PUT /Groups/{id}", handleSCIMReplaceGroup)
+	scim.HandleFunc("PATCH /Groups/{id}", handleSCIMPatchGroup)
+	scim.HandleFunc("DELETE /Groups/{id}", handleSCIMDeleteGroup)
+	mux.Handle("/scim/v2/", http.StripPrefix("/scim/v2", scimAuth(scim)))
+
 	// Authenticated routes
 	app := http.NewServeMux()
 	app.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
 	})
 	app.HandleFunc("GET /dashboard", handleDashboard)
+	app.HandleFunc("GET /search", handleSearch)
+	app.HandleFunc("GET /account", handleAccount)
+	app.HandleFunc("POST /account", handleAccount)
+	app.HandleFunc("GET /avatars/{id}", handleAvatar)
+	app.HandleFunc("POST /account/passkeys/begin", handlePasskeyRegisterBegin)
+	app.HandleFunc("POST /account/passkeys/finish", handlePasskeyRegisterFinish)
+
+	// Admin console
+	app.HandleFunc("GET /admin/users", handleAdminUsers)
+	app.HandleFunc("POST /admin/users/{id}", handleAdminUpdateUser)
+	app.HandleFunc("POST /admin/users/{id}/impersonate", handleImpersonate)
+	app.HandleFunc("GET /admin/domains", handleAdminDomains)
+	app.HandleFunc("POST /admin/domains", handleAdminUpdateDomains)
+	app.HandleFunc("GET /admin/scim", handleAdminSCIM)
+	app.HandleFunc("POST /admin/scim", handleAdminSCIM)
+	app.HandleFunc("GET /admin/audit", handleAdminAudit)
+	app.HandleFunc("POST /impersonate/stop", handleStopImpersonation)
 	app.HandleFunc("POST /projects", handleCreateProject)
 	app.HandleFunc("GET /projects/{slug}", handleProject)
 	app.HandleFunc("GET /projects/{slug}/settings", handleProjectSettings)
 	app.HandleFunc("POST /projects/{slug}/settings", handleProjectSettings)
+	app.HandleFunc("GET /projects/{slug}/audit", handleProjectAudit)
+	app.HandleFunc("GET /projects/{slug}/docs/{doc}", handleProjectDoc)
 
 	// Issues
+	app.HandleFunc("GET /projects/{slug}/issues", handleIssuesTable)
 	app.HandleFunc("POST /projects/{slug}/issues", handleCreateIssue)
+	app.HandleFunc("POST /projects/{slug}/issues/reorder", handleReorderIssues)
+	app.HandleFunc("GET /projects/{slug}/issues/export", handleExportIssues)
+	app.HandleFunc("GET /projects/{slug}/board", handleBoard)
+	app.HandleFunc("GET /projects/{slug}/issues/{id}", handleIssue)
+	app.HandleFunc("POST /projects/{slug}/issues/{id}/comments", handleCreateComment)
+	app.HandleFunc("POST /projects/{slug}/issues/{id}/comments/{cid}/delete", handleDeleteComment)
+	app.HandleFunc("POST /projects/{slug}/issues/{id}/relations", handleAddRelation)
+	app.HandleFunc("POST /projects/{slug}/issues/{id}/relations/{rid}/delete", handleDeleteRelation)
+	app.HandleFunc("POST /projects/{slug}/issues/{id}/checklist", handleAddChecklistItem)
+	app.HandleFunc("POST /projects/{slug}/issues/{id}/checklist/{item}", handleUpdateChecklistItem)
+	app.HandleFunc("POST /projects/{slug}/issues/{id}/checklist/{item}/delete", handleDeleteChecklistItem)
 	app.HandleFunc("PUT /projects/{slug}/issues/{id}", handleUpdateIssue)
 	app.HandleFunc("POST /projects/{slug}/issues/{id}", handleUpdateIssue)
 	app.HandleFunc("DELETE /projects/{slug}/issues/{id}", handleDeleteIssue)
@@ -96,7 +160,7 @@ func main() {
 	app.HandleFunc("GET /{slug}", handleProjectDashboard)
 	app.HandleFunc("GET /{slug}/{path...}", handleProjectDashboardAsset)
 
-	mux.Handle("/", authMiddleware(app))
+	mux.Handle("/", authMiddleware(csrfMiddleware(auditImpersonation(app))))
 
 	addr := fmt.Sprintf(":%s", cfg.Port)
 	log.Printf("Portal running on %s", addr)
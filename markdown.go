@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// renderMarkdown converts the common subset of Markdown used in issue
+// descriptions and comments: headings, paragraphs, lists, task lists, quotes,
+// code, emphasis and links. The source is escaped first, so raw HTML shows as
+// text and only http(s), mailto and relative links are produced.
+func renderMarkdown(src string) template.HTML {
+	// NUL marks placeholders in renderInline
+	src = strings.ReplaceAll(src, "\x00", "")
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	var b strings.Builder
+	renderBlocks(&b, lines)
+	return template.HTML(b.String())
+}
+
+var (
+	mdHeading  = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdRule     = regexp.MustCompile(`^\s*(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	mdBullet   = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	mdOrdered  = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	mdTask     = regexp.MustCompile(`^\[([ xX])\]\s+(.*)$`)
+	mdLink     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	mdAutolink = regexp.MustCompile("https?://[^\\s<\x00]+[^\\s<\x00.,;:!?)]")
+	mdBold     = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	mdItalic   = regexp.MustCompile(`\*([^*\s][^*]*)\*|\b_([^_\s][^_]*)_\b`)
+	mdStrike   = regexp.MustCompile(`~~(.+?)~~`)
+	mdToken    = regexp.MustCompile("\x00(\\d+)\x00")
+)
+
+func renderBlocks(b *strings.Builder, lines []string) {
+	var para []string
+	flush := func() {
+		if len(para) > 0 {
+			b.WriteString("<p>")
+			for i, l := range para {
+				if i > 0 {
+					b.WriteString("<br>\n")
+				}
+				b.WriteString(renderInline(strings.TrimSpace(l)))
+			}
+			b.WriteString("</p>\n")
+			para = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			flush()
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			b.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+		case trimmed == "":
+			flush()
+		case mdHeading.MatchString(trimmed):
+			flush()
+			m := mdHeading.FindStringSubmatch(trimmed)
+			fmt.Fprintf(b, "<h%d>%s</h%d>\n", len(m[1]), renderInline(m[2]), len(m[1]))
+		case mdRule.MatchString(line):
+			flush()
+			b.WriteString("<hr>\n")
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				q := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quote = append(quote, strings.TrimPrefix(q, " "))
+			}
+			i--
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, quote)
+			b.WriteString("</blockquote>\n")
+		case mdBullet.MatchString(line) || mdOrdered.MatchString(line):
+			flush()
+			item, tag := mdBullet, "ul"
+			if !mdBullet.MatchString(line) {
+				item, tag = mdOrdered, "ol"
+			}
+			b.WriteString("<" + tag + ">\n")
+			for ; i < len(lines) && item.MatchString(lines[i]); i++ {
+				text := item.FindStringSubmatch(lines[i])[1]
+				if m := mdTask.FindStringSubmatch(text); m != nil {
+					checked := ""
+					if m[1] != " " {
+						checked = " checked"
+					}
+					b.WriteString(`<li class="task-item"><input type="checkbox" disabled` + checked + "> " + renderInline(m[2]) + "</li>\n")
+					continue
+				}
+				b.WriteString("<li>" + renderInline(text) + "</li>\n")
+			}
+			i--
+			b.WriteString("</" + tag + ">\n")
+		default:
+			para = append(para, line)
+		}
+	}
+	flush()
+}
+
+// renderInline escapes s and applies inline formatting. Code spans and links
+// are swapped for placeholders so later patterns can't reach inside them;
+// mdAutolink stops at the NUL around a placeholder for the same reason.
+func renderInline(s string) string {
+	var saved []string
+	hold := func(h string) string {
+		saved = append(saved, h)
+		return fmt.Sprintf("\x00%d\x00", len(saved)-1)
+	}
+
+	var b strings.Builder
+	parts := strings.Split(s, "`")
+	for i, part := range parts {
+		if i%2 == 1 && i < len(parts)-1 {
+			b.WriteString(hold("<code>" + html.EscapeString(part) + "</code>"))
+			continue
+		}
+		if i%2 == 1 {
+			b.WriteString("`")
+		}
+		b.WriteString(html.EscapeString(part))
+	}
+	out := b.String()
+
+	out = mdLink.ReplaceAllStringFunc(out, func(m string) string {
+		sub := mdLink.FindStringSubmatch(m)
+		if !safeLinkURL(html.UnescapeString(sub[2])) {
+			return m
+		}
+		return hold(`<a href="` + sub[2] + `" rel="noopener noreferrer">` + emphasis(sub[1]) + "</a>")
+	})
+	out = mdAutolink.ReplaceAllStringFunc(out, func(m string) string {
+		return hold(`<a href="` + m + `" rel="noopener noreferrer">` + m + "</a>")
+	})
+	out = emphasis(out)
+	for mdToken.MatchString(out) {
+		out = mdToken.ReplaceAllStringFunc(out, func(m string) string {
+			var n int
+			fmt.Sscanf(mdToken.FindStringSubmatch(m)[1], "%d", &n)
+			return saved[n]
+		})
+	}
+	return out
+}
+
+func emphasis(s string) string {
+	s = mdBold.ReplaceAllString(s, "<strong>$1$2</strong>")
+	s = mdItalic.ReplaceAllString(s, "<em>$1$2</em>")
+	return mdStrike.ReplaceAllString(s, "<del>$1</del>")
+}
+
+// safeLinkURL allows absolute http(s) and mailto links, fragments, and paths
+// on this site. Browsers read "/\host" as "//host" and ignore tabs and line
+// breaks, so a path only counts as local if it has no host once those are
+// accounted for.
+func safeLinkURL(u string) bool {
+	lower := strings.ToLower(u)
+	for _, p := range []string{"http://", "https://", "mailto:"} {
+		if strings.HasPrefix(lower, p) {
+			return true
+		}
+	}
+	if strings.HasPrefix(u, "/") {
+		clean := strings.NewReplacer("\t", "", "\n", "", "\r", "", "\\", "/").Replace(u)
+		parsed, err := url.Parse(clean)
+		return err == nil && parsed.Host == "" && !strings.HasPrefix(clean, "//")
+	}
+	return strings.HasPrefix(u, "#")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdownLinks(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"link", "[docs](https://example.com/a?b=1&c=2)",
+			`<p><a href="https://example.com/a?b=1&amp;c=2" rel="noopener noreferrer">docs</a></p>`},
+		{"relative link", "[tarea](/projects/acme/issues/ACME-1)",
+			`<p><a href="/projects/acme/issues/ACME-1" rel="noopener noreferrer">tarea</a></p>`},
+		{"autolink", "ver https://example.com/x.",
+			`<p>ver <a href="https://example.com/x" rel="noopener noreferrer">https://example.com/x</a>.</p>`},
+		{"autolink next to link", "see http://a[x](http://b/onmouseover=location=name) end",
+			`<p>see http://a<a href="http://b/onmouseover=location=name" rel="noopener noreferrer">x</a> end</p>`},
+		{"autolink touching link", "http://a.io[x](http://b.io/\"onmouseover=x)",
+			`<p><a href="http://a.io" rel="noopener noreferrer">http://a.io</a>` +
+				`<a href="http://b.io/&#34;onmouseover=x" rel="noopener noreferrer">x</a></p>`},
+		{"link text is a URL", "[http://a](http://b)",
+			`<p><a href="http://b" rel="noopener noreferrer">http://a</a></p>`},
+		{"nested link", "[[x](http://a.io)](http://b.io)",
+			`<p><a href="http://a.io" rel="noopener noreferrer">[x</a>](<a href="http://b.io" rel="noopener noreferrer">http://b.io</a>)</p>`},
+		{"link in link text", "[a [b](http://a.io) c](http://b.io)",
+			`<p><a href="http://a.io" rel="noopener noreferrer">a [b</a> c](<a href="http://b.io" rel="noopener noreferrer">http://b.io</a>)</p>`},
+		{"javascript", "[x](javascript:alert(1))", `<p>[x](javascript:alert(1))</p>`},
+		{"javascript mixed case", "[x](JaVaScRiPt:alert)", `<p>[x](JaVaScRiPt:alert)</p>`},
+		{"data", "[x](data:text/html,hi)", `<p>[x](data:text/html,hi)</p>`},
+		{"protocol relative", "[x](//evil.example)", `<p>[x](//evil.example)</p>`},
+		{"backslash", `[x](/\evil.example)`, `<p>[x](/\evil.example)</p>`},
+		{"code span", "`[x](http://a)`", `<p><code>[x](http://a)</code></p>`},
+		{"quote in URL", `[x](http://a/"onmouseover=x)`,
+			`<p><a href="http://a/&#34;onmouseover=x" rel="noopener noreferrer">x</a></p>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.TrimSpace(string(renderMarkdown(tt.src)))
+			if got != tt.want {
+				t.Errorf("renderMarkdown(%q)\n got %s\nwant %s", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderMarkdownEscapes(t *testing.T) {
+	for _, src := range []string{
+		`<script>alert(1)</script>`,
+		`<img src=x onerror=alert(1)>`,
+		"**<b>**", "`<b>`", "> <i>x</i>", "- <u>x</u>",
+		"a\x000\x00b",
+	} {
+		got := string(renderMarkdown(src))
+		if strings.Contains(got, "<script") || strings.Contains(got, "<img") ||
+			strings.Contains(got, "<b>") || strings.Contains(got, "<i>") || strings.Contains(got, "<u>") {
+			t.Errorf("renderMarkdown(%q) = %q lets raw HTML through", src, got)
+		}
+	}
+}
+
+func TestRenderMarkdownBlocks(t *testing.T) {
+	got := string(renderMarkdown("# Título\n\n- [x] hecho\n- [ ] **falta**\n\n```\n<b>\n```"))
+	for _, want := range []string{
+		"<h1>Título</h1>",
+		`<li class="task-item"><input type="checkbox" disabled checked> hecho</li>`,
+		`<li class="task-item"><input type="checkbox" disabled> <strong>falta</strong></li>`,
+		"<pre><code>&lt;b&gt;</code></pre>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %s in\n%s", want, got)
+		}
+	}
+}
+
+func TestSafeLinkURL(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://example.com", true},
+		{"HTTP://example.com", true},
+		{"mailto:ana@example.com", true},
+		{"/projects/acme", true},
+		{"#comment-3", true},
+		{"//evil.example", false},
+		{`/\evil.example`, false},
+		{"/\t/evil.example", false},
+		{"/\n/evil.example", false},
+		{"javascript:alert(1)", false},
+		{"vbscript:x", false},
+		{"data:text/html,x", false},
+		{"evil.example", false},
+	}
+	for _, tt := range tests {
+		if got := safeLinkURL(tt.url); got != tt.ok {
+			t.Errorf("safeLinkURL(%q) = %v, want %v", tt.url, got, tt.ok)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"net/http"
 	"time"
 )
@@ -25,25 +26,95 @@ func authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 		var userID int64
+		var impersonatingID *int64
 		var expiresAt time.Time
+		var csrfToken string
 		err = db.QueryRow(
-			"SELECT user_id, expires_at FROM sessions WHERE token = ?",
+			"SELECT user_id, impersonating_id, expires_at, csrf_token FROM sessions WHERE token = ?",
 			cookie.Value,
-		).Scan(&userID, &expiresAt)
+		).Scan(&userID, &impersonatingID, &expiresAt, &csrfToken)
 		if err != nil || time.Now().After(expiresAt) {
 			http.SetCookie(w, &http.Cookie{Name: "session", MaxAge: -1, Path: "/"})
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
-		var u User
-		err = db.QueryRow(
-			"SELECT id, email, name, role FROM users WHERE id = ?", userID,
-		).Scan(&u.ID, &u.Email, &u.Name, &u.Role)
-		if err != nil {
+		// Sessions created before CSRF protection get a token on first use
+		if csrfToken == "" {
+			csrfToken = generateToken()
+			db.Exec("UPDATE sessions SET csrf_token = ? WHERE token = ?", csrfToken, cookie.Value)
+		}
+		u, err := loadUser(userID)
+		if err != nil || u.DeactivatedAt != nil {
+			http.SetCookie(w, &http.Cookie{Name: "session", MaxAge: -1, Path: "/"})
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
-		ctx := context.WithValue(r.Context(), userKey, &u)
+		// An admin viewing the portal as someone else acts as that user. The
+		// impersonation ends on its own if either side stops qualifying.
+		if impersonatingID != nil {
+			target, err := loadUser(*impersonatingID)
+			if err != nil || !canImpersonate(u, target) {
+				db.Exec("UPDATE sessions SET impersonating_id = NULL WHERE token = ?", cookie.Value)
+			} else {
+				target.Impersonator = u
+				u = target
+			}
+		}
+		u.CSRFToken = csrfToken
+		ctx := context.WithValue(r.Context(), userKey, u)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// csrfMiddleware rejects state-changing requests that don't carry the
+// session's CSRF token, either in the X-CSRF-Token header (HTMX, fetch) or
+// the csrf_token form field (plain forms). Must run after authMiddleware.
+func csrfMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS":
+			next.ServeHTTP(w, r)
+			return
+		}
+		u := currentUser(r)
+		token := r.Header.Get("X-CSRF-Token")
+		if token == "" {
+			token = r.FormValue("csrf_token")
+		}
+		if u == nil || u.CSRFToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(u.CSRFToken)) != 1 {
+			http.Error(w, "Token CSRF inválido o ausente. Recarga la página e inténtalo de nuevo.", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// auditImpersonation records every state-changing request made while an admin
+// is impersonating, under both identities. Must run after csrfMiddleware so
+// only accepted requests are logged.
+func auditImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Stopping is logged by its handler
+		if u := currentUser(r); u != nil && u.Impersonator != nil && r.URL.Path != "/impersonate/stop" {
+			switch r.Method {
+			case "GET", "HEAD", "OPTIONS":
+			default:
+				recordAudit(r, u, r.Method+" "+r.URL.Path, "")
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func loadUser(id int64) (*User, error) {
+	var u User
+	err := db.QueryRow(
+		`SELECT id, email, name, role, avatar_path, language, timezone, notify_assigned, notify_files, deactivated_at
+		FROM users WHERE id = ?`, id,
+	).Scan(&u.ID, &u.Email, &u.Name, &u.Role, &u.AvatarPath, &u.Language, &u.Timezone,
+		&u.NotifyAssigned, &u.NotifyFiles, &u.DeactivatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
@@ -1,21 +1,39 @@
 package main
 
-import "time"
+import (
+	"html/template"
+	"time"
+)
 
 type User struct {
-	ID        int64
-	Email     string
-	Name      string
-	Role      string
-	CreatedAt time.Time
+	ID             int64
+	Email          string
+	Name           string
+	Role           string
+	AvatarPath     string // file name under <upload_dir>/avatars, empty if none
+	Language       string
+	Timezone       string // IANA name, empty for the server's zone
+	NotifyAssigned bool
+	NotifyFiles    bool
+	TOTPEnabled    bool
+	DeactivatedAt  *time.Time
+	LastLoginAt    *time.Time
+	CreatedAt      time.Time
+	CSRFToken      string    // current session's token, set by authMiddleware
+	Impersonator   *User     // admin viewing the portal as this user, set by authMiddleware
+	Memberships    []Project // joined, admin console
 }
 
 type MagicToken struct {
-	ID         int64
-	Email      string
-	Token      string
-	CreatedAt  time.Time
-	ApprovedAt *time.Time
+	ID            int64
+	Email         string
+	Token         string
+	Code          string
+	CodeAttempts  int
+	CreatedAt     time.Time
+	ApprovedAt    *time.Time
+	MFAVerifiedAt *time.Time
+	MFAAttempts   int
 }
 
 type Session struct {
@@ -26,15 +44,84 @@ type Session struct {
 	ExpiresAt time.Time
 }
 
+type Passkey struct {
+	ID         int64
+	UserID     int64
+	Name       string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
 type Project struct {
 	ID          int64
 	Name        string
 	Slug        string
 	Description string
+	IssuePrefix string
 	CreatedAt   time.Time
 	MemberRole  string // populated by query context
 }
 
+type APIKey struct {
+	ID        int64
+	ProjectID int64
+	Key       string
+	CreatedBy *int64
+	CreatedAt time.Time
+	Creator   *User // joined
+}
+
+type Invitation struct {
+	ID         int64
+	ProjectID  int64
+	Email      string
+	Role       string
+	Token      string
+	InvitedBy  *int64
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	AcceptedAt *time.Time
+	DeclinedAt *time.Time
+	Expired    bool     // computed
+	Inviter    *User    // joined
+	Project    *Project // joined
+}
+
+type PersonalToken struct {
+	ID         int64
+	UserID     int64
+	Name       string
+	Prefix     string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+type DomainRule struct {
+	ID              int64
+	Domain          string
+	ProjectID       int64
+	Role            string
+	RequireApproval bool
+	CreatedAt       time.Time
+	Project         *Project // joined
+}
+
+type SignupRequest struct {
+	ID        int64
+	Email     string
+	Name      string
+	CreatedAt time.Time
+	Rules     []DomainRule // matching rules, admin console
+}
+
+type SCIMGroup struct {
+	ID          int64
+	DisplayName string
+	ProjectID   *int64
+	Role        string
+	MemberCount int // computed
+}
+
 type ProjectMember struct {
 	ID        int64
 	ProjectID int64
@@ -44,33 +131,45 @@ type ProjectMember struct {
 }
 
 type Milestone struct {
-	ID          int64
-	ProjectID   int64
-	Name        string
-	Description string
-	TargetDate  *string
-	Position    int
-	CreatedAt   time.Time
-	TotalIssues int // computed
-	DoneIssues  int // computed
+	ID             int64
+	ProjectID      int64
+	Name           string
+	Description    string
+	TargetDate     *string
+	Position       int
+	CreatedAt      time.Time
+	TotalIssues    int // computed
+	DoneIssues     int // computed
+	ChecklistTotal int // computed: checklist items of its issues
+	ChecklistDone  int // computed
+	Progress       int // computed: percent, with partial credit for checklists
 }
 
 type Issue struct {
-	ID          int64
-	ProjectID   int64
-	Title       string
-	Description string
-	Status      string
-	Priority    string
-	AssigneeID  *int64
-	DueDate     *string
-	MilestoneID *int64
-	Position    int
-	CreatedBy   *int64
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
-	Assignee    *User      // joined
-	Milestone   *Milestone // joined
+	ID             int64
+	ProjectID      int64
+	Title          string
+	Description    string
+	Status         string
+	Priority       string
+	AssigneeID     *int64
+	DueDate        *string
+	MilestoneID    *int64
+	Position       int
+	CreatedBy      *int64
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Assignee       *User                // joined
+	Creator        *User                // joined
+	Milestone      *Milestone           // joined
+	Labels         []Label              // joined
+	StatusCategory string               // joined
+	BlockedBy      []string             // computed: titles of unfinished blocking issues
+	Fields         map[int64]FieldValue // custom field values by field id
+	Number         int64                // within the project
+	Key            string               // joined: project prefix and number, e.g. ACME-42
+	ChecklistTotal int                  // computed
+	ChecklistDone  int                  // computed
 }
 
 type Folder struct {
@@ -102,3 +201,131 @@ type Message struct {
 	CreatedAt time.Time
 	User      *User // joined
 }
+
+type AuditEntry struct {
+	ID           int64
+	UserID       *int64
+	Actor        string // email, or the credential label for API keys and SCIM
+	Via          string // credential other than a session, if any
+	Impersonator string
+	ProjectID    *int64
+	Action       string
+	Target       string
+	Before       *string // JSON
+	After        *string // JSON
+	IP           string
+	CreatedAt    time.Time
+	Project      *Project // joined
+}
+
+// ProjectRole is a row of the permission matrix in the project settings.
+type ProjectRole struct {
+	ID      int64
+	Name    string
+	Builtin bool
+	Perms   map[string]bool
+	Members int // count of members holding it
+}
+
+type BoardColumn struct {
+	Status IssueStatus
+	Issues []Issue
+}
+
+type Comment struct {
+	ID        int64
+	IssueID   int64
+	ParentID  *int64
+	UserID    *int64
+	Body      string
+	CreatedAt time.Time
+	DeletedAt *time.Time
+	User      *User      // joined
+	Replies   []*Comment // built from ParentID
+}
+
+// IssueEvent is one field change in the history of an issue.
+type IssueEvent struct {
+	ID        int64
+	IssueID   int64
+	UserID    *int64
+	Actor     string // email, or the credential label for API keys
+	Field     string
+	OldValue  *string
+	NewValue  *string
+	CreatedAt time.Time
+	User      *User  // joined
+	OldName   string // joined: assignee or milestone name
+	NewName   string // joined
+}
+
+type StatusDuration struct {
+	Status   string
+	Duration time.Duration
+}
+
+// CustomField is an issue field defined by a project. Options are the
+// choices of a select field.
+type CustomField struct {
+	ID        int64
+	ProjectID int64
+	Name      string
+	Type      string // text, number, date, select or user
+	Options   []string
+	Issues    int // computed: issues with a value
+}
+
+// FieldValue is the value of a custom field on an issue. Display is the
+// user's name for user fields and the value itself otherwise.
+type FieldValue struct {
+	Value   string
+	Display string
+}
+
+type Label struct {
+	ID        int64
+	ProjectID int64
+	Name      string
+	Color     string
+	Issues    int // computed
+}
+
+// IssueRelation is a relation seen from one issue: Kind is blocks,
+// blocked_by, relates, duplicates or duplicated_by, and Issue is the other end.
+type IssueRelation struct {
+	ID    int64
+	Kind  string
+	Issue *Issue
+}
+
+// SearchResult is an issue, chat message, file or project document matching
+// a search. TitleHTML and Snippet have the matched words in <mark>.
+type SearchResult struct {
+	Kind      string
+	Ref       int64
+	Number    int64
+	Project   *Project
+	TitleHTML template.HTML
+	Snippet   template.HTML
+}
+
+type ChecklistItem struct {
+	ID        int64
+	IssueID   int64
+	Body      string
+	Done      bool
+	Position  int
+	CreatedAt time.Time
+}
+
+// IssueStatus is a step of a project's workflow. Key is what issues.status
+// stores; Category is open, active or done.
+type IssueStatus struct {
+	ID        int64
+	ProjectID int64
+	Key       string
+	Name      string
+	Category  string
+	Position  int
+	Issues    int // computed
+}
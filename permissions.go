@@ -0,0 +1,209 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Project permissions. Built-in roles grant fixed sets; owners can define
+// custom roles per project with any combination.
+const (
+	permIssuesCreate     = "issues.create"
+	permIssuesEdit       = "issues.edit"
+	permIssuesDelete     = "issues.delete"
+	permMilestonesEdit   = "milestones.edit"
+	permMilestonesDelete = "milestones.delete"
+	permFilesUpload      = "files.upload"
+	permFilesDelete      = "files.delete"
+	permComment          = "comments.create"
+	permPublish          = "reports.publish"
+	permManage           = "project.manage"
+	permAuditView        = "audit.view"
+)
+
+// Permission is a column of the permission matrix in the project settings.
+type Permission struct {
+	Key   string
+	Label string
+}
+
+var allPermissions = []Permission{
+	{permIssuesCreate, "Crear tareas"},
+	{permIssuesEdit, "Editar tareas"},
+	{permIssuesDelete, "Eliminar tareas"},
+	{permMilestonesEdit, "Crear y editar hitos"},
+	{permMilestonesDelete, "Eliminar hitos"},
+	{permFilesUpload, "Subir archivos y crear carpetas"},
+	{permFilesDelete, "Eliminar archivos"},
+	{permComment, "Comentar"},
+	{permPublish, "Publicar estado, roadmap y dashboard"},
+	{permManage, "Gestionar miembros, roles y claves API"},
+	{permAuditView, "Ver auditoría"},
+}
+
+// builtinRoles keeps the historical behaviour: clients read and comment, members
+// do everything except administer the project, owners do everything.
+var builtinRoles = map[string][]string{
+	"owner": {permIssuesCreate, permIssuesEdit, permIssuesDelete, permMilestonesEdit, permMilestonesDelete,
+		permFilesUpload, permFilesDelete, permComment, permPublish, permManage, permAuditView},
+	"member": {permIssuesCreate, permIssuesEdit, permIssuesDelete, permMilestonesEdit, permMilestonesDelete,
+		permFilesUpload, permFilesDelete, permComment, permPublish},
+	"client": {permComment},
+}
+
+var builtinRoleOrder = []string{"owner", "member", "client"}
+
+var roleNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// Access is what the current user may do in a project.
+type Access struct {
+	Role  string
+	perms map[string]bool
+}
+
+func (a *Access) Can(perm string) bool {
+	return a != nil && a.perms[perm]
+}
+
+// projectAccess resolves role into permissions. Site admins who aren't
+// members get every permission; admins with a membership keep project
+// administration on top of their role.
+func projectAccess(projectID int64, u *User, role string) *Access {
+	a := &Access{Role: role, perms: map[string]bool{}}
+	if role == "admin" {
+		for _, p := range allPermissions {
+			a.perms[p.Key] = true
+		}
+		return a
+	}
+	for _, p := range rolePermissions(projectID, role) {
+		a.perms[p] = true
+	}
+	if u.Role == "admin" {
+		a.perms[permManage] = true
+		a.perms[permAuditView] = true
+	}
+	return a
+}
+
+// holdsAll reports whether the user has every one of perms.
+func (a *Access) holdsAll(perms []string) bool {
+	for _, p := range perms {
+		if !a.Can(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// canGrant reports whether the user may give role to a member or invitee.
+// Owners and site admins may give any role; others only roles whose
+// permissions they hold themselves, and never owner, so project.manage
+// doesn't escalate to the full set.
+func (a *Access) canGrant(projectID int64, u *User, role string) bool {
+	if a.Role == "owner" || u.Role == "admin" {
+		return true
+	}
+	return role != "owner" && a.holdsAll(rolePermissions(projectID, role))
+}
+
+// canCreateAPIKey reports whether the user may create a project API key. A
+// key acts with every permission in the project, so it takes the same
+// standing as granting owner.
+func (a *Access) canCreateAPIKey(projectID int64, u *User) bool {
+	return a.canGrant(projectID, u, "owner")
+}
+
+func rolePermissions(projectID int64, role string) []string {
+	if perms, ok := builtinRoles[role]; ok {
+		return perms
+	}
+	var perms string
+	db.QueryRow("SELECT permissions FROM project_roles WHERE project_id = ? AND name = ?", projectID, role).Scan(&perms)
+	return strings.Fields(perms)
+}
+
+// authorizeProject loads the project in the URL for the current user and
+// checks perm, answering 403 when either is missing.
+func authorizeProject(w http.ResponseWriter, r *http.Request, perm string) (*Project, *Access, bool) {
+	u := currentUser(r)
+	p, role := getProjectForUser(r.PathValue("slug"), u)
+	if p == nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, nil, false
+	}
+	access := projectAccess(p.ID, u, role)
+	if !access.Can(perm) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return p, access, true
+}
+
+// projectRoles lists the built-in roles followed by the project's own.
+func projectRoles(projectID int64) []ProjectRole {
+	counts := map[string]int{}
+	rows, err := db.Query("SELECT role, COUNT(*) FROM project_members WHERE project_id = ? GROUP BY role", projectID)
+	if err == nil {
+		for rows.Next() {
+			var role string
+			var n int
+			rows.Scan(&role, &n)
+			counts[role] = n
+		}
+		rows.Close()
+	}
+
+	var roles []ProjectRole
+	for _, name := range builtinRoleOrder {
+		pr := ProjectRole{Name: name, Builtin: true, Perms: map[string]bool{}, Members: counts[name]}
+		for _, p := range builtinRoles[name] {
+			pr.Perms[p] = true
+		}
+		roles = append(roles, pr)
+	}
+	rows, err = db.Query("SELECT id, name, permissions FROM project_roles WHERE project_id = ? ORDER BY name", projectID)
+	if err != nil {
+		return roles
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pr ProjectRole
+		var perms string
+		rows.Scan(&pr.ID, &pr.Name, &perms)
+		pr.Perms = map[string]bool{}
+		for _, p := range strings.Fields(perms) {
+			pr.Perms[p] = true
+		}
+		pr.Members = counts[pr.Name]
+		roles = append(roles, pr)
+	}
+	return roles
+}
+
+// validProjectRole reports whether role can be given to members of the project.
+func validProjectRole(projectID int64, role string) bool {
+	if _, ok := builtinRoles[role]; ok {
+		return true
+	}
+	var exists bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM project_roles WHERE project_id = ? AND name = ?)", projectID, role).Scan(&exists)
+	return exists
+}
+
+// permissionsFromForm keeps the known permissions checked in the form.
+func permissionsFromForm(r *http.Request) []string {
+	r.ParseForm()
+	checked := map[string]bool{}
+	for _, p := range r.Form["perm"] {
+		checked[p] = true
+	}
+	var perms []string
+	for _, p := range allPermissions {
+		if checked[p.Key] {
+			perms = append(perms, p.Key)
+		}
+	}
+	return perms
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// seedProject creates the project acme with an owner, a member, a client and
+// a "lead" who may manage the project but not publish. It returns those
+// users by role.
+func seedProject(t *testing.T) map[string]*User {
+	t.Helper()
+	mustExec(t,
+		`INSERT INTO users (id, email, name, role) VALUES (1, 'owner@example.com', 'Owner', 'user'),
+			(2, 'member@example.com', 'Member', 'user'), (3, 'client@example.com', 'Client', 'client'),
+			(4, 'lead@example.com', 'Lead', 'user'), (5, 'admin@example.com', 'Admin', 'admin'),
+			(6, 'new@example.com', 'New', 'user')`,
+		"INSERT INTO projects (id, name, slug, issue_prefix) VALUES (1, 'Acme', 'acme', 'ACME')",
+		"INSERT INTO project_roles (project_id, name, permissions) VALUES (1, 'lead', 'project.manage issues.edit comments.create')",
+		`INSERT INTO project_members (project_id, user_id, role) VALUES (1, 1, 'owner'), (1, 2, 'member'),
+			(1, 3, 'client'), (1, 4, 'lead')`,
+	)
+	users := map[string]*User{}
+	for id, role := range map[int64]string{1: "owner", 2: "member", 3: "client", 4: "lead", 5: "admin", 6: "none"} {
+		u, err := loadUser(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[role] = u
+	}
+	return users
+}
+
+// asUser is r as sent by u through the auth middleware.
+func asUser(r *http.Request, u *User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userKey, u))
+}
+
+// postAs posts form to h as u, with the path values given as name, value
+// pairs.
+func postAs(h http.HandlerFunc, u *User, form url.Values, path ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for i := 0; i+1 < len(path); i += 2 {
+		req.SetPathValue(path[i], path[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h(rec, asUser(req, u))
+	return rec
+}
+
+func accessOf(t *testing.T, u *User) *Access {
+	t.Helper()
+	p, role := getProjectForUser("acme", u)
+	if p == nil {
+		return nil
+	}
+	return projectAccess(p.ID, u, role)
+}
+
+func TestProjectAccess(t *testing.T) {
+	openTestDB(t)
+	users := seedProject(t)
+	all := []string{permIssuesCreate, permIssuesEdit, permIssuesDelete, permMilestonesEdit, permMilestonesDelete,
+		permFilesUpload, permFilesDelete, permComment, permPublish, permManage, permAuditView}
+	want := map[string][]string{
+		"owner":  all,
+		"admin":  all,
+		"member": {permIssuesCreate, permIssuesEdit, permIssuesDelete, permMilestonesEdit, permMilestonesDelete, permFilesUpload, permFilesDelete, permComment, permPublish},
+		"client": {permComment},
+		"lead":   {permManage, permIssuesEdit, permComment},
+	}
+	for role, perms := range want {
+		a := accessOf(t, users[role])
+		allowed := map[string]bool{}
+		for _, p := range perms {
+			allowed[p] = true
+		}
+		for _, p := range all {
+			if a.Can(p) != allowed[p] {
+				t.Errorf("%s: Can(%s) = %v", role, p, a.Can(p))
+			}
+		}
+	}
+	if accessOf(t, users["none"]) != nil {
+		t.Error("a non-member can open the project")
+	}
+
+	// Admins who are members keep project administration on top of their role.
+	mustExec(t, "INSERT INTO project_members (project_id, user_id, role) VALUES (1, 5, 'client')")
+	a := accessOf(t, users["admin"])
+	if !a.Can(permManage) || !a.Can(permAuditView) || a.Can(permIssuesEdit) {
+		t.Errorf("admin with client membership: manage %v, audit %v, edit %v",
+			a.Can(permManage), a.Can(permAuditView), a.Can(permIssuesEdit))
+	}
+}
+
+func TestCanGrant(t *testing.T) {
+	openTestDB(t)
+	users := seedProject(t)
+	mustExec(t, "INSERT INTO project_roles (project_id, name, permissions) VALUES (1, 'reader', 'comments.create'), (1, 'publisher', 'reports.publish')")
+	tests := []struct {
+		user, role string
+		ok         bool
+	}{
+		{"owner", "owner", true},
+		{"owner", "publisher", true},
+		{"admin", "owner", true},
+		{"lead", "client", true},
+		{"lead", "reader", true},
+		{"lead", "lead", true},
+		{"lead", "owner", false},
+		{"lead", "member", false},
+		{"lead", "publisher", false},
+	}
+	for _, tt := range tests {
+		if got := accessOf(t, users[tt.user]).canGrant(1, users[tt.user], tt.role); got != tt.ok {
+			t.Errorf("%s granting %s = %v, want %v", tt.user, tt.role, got, tt.ok)
+		}
+	}
+	for role, ok := range map[string]bool{"owner": true, "admin": true, "lead": false, "member": false} {
+		if got := accessOf(t, users[role]).canCreateAPIKey(1, users[role]); got != ok {
+			t.Errorf("%s creating an API key = %v, want %v", role, got, ok)
+		}
+	}
+}
+
+func TestProjectSettingsEscalation(t *testing.T) {
+	openTestDB(t)
+	initTemplates()
+	users := seedProject(t)
+	post := func(u *User, form url.Values) int {
+		return postAs(handleProjectSettings, u, form, "slug", "acme").Code
+	}
+	roleOf := func(userID int) string {
+		var role string
+		db.QueryRow("SELECT role FROM project_members WHERE project_id = 1 AND user_id = ?", userID).Scan(&role)
+		return role
+	}
+	keys := func() int {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM api_keys WHERE project_id = 1").Scan(&n)
+		return n
+	}
+
+	denied := []struct {
+		name string
+		form url.Values
+	}{
+		{"promote to owner", url.Values{"action": {"set_member_role"}, "user_id": {"3"}, "role": {"owner"}}},
+		{"promote to member", url.Values{"action": {"set_member_role"}, "user_id": {"3"}, "role": {"member"}}},
+		{"demote the owner", url.Values{"action": {"set_member_role"}, "user_id": {"1"}, "role": {"client"}}},
+		{"remove the owner", url.Values{"action": {"remove_member"}, "user_id": {"1"}}},
+		{"invite an owner", url.Values{"action": {"add_member"}, "email": {"new@example.com"}, "role": {"owner"}}},
+		{"role beyond own", url.Values{"action": {"create_role"}, "name": {"pub"}, "perm": {"reports.publish"}}},
+		{"API key", url.Values{"action": {"create_api_key"}}},
+	}
+	for _, tt := range denied {
+		if code := post(users["lead"], tt.form); code != http.StatusForbidden {
+			t.Errorf("lead: %s answered %d, want 403", tt.name, code)
+		}
+	}
+	if roleOf(1) != "owner" || roleOf(3) != "client" || keys() != 0 {
+		t.Fatalf("a denied change went through: owner %q, client %q, %d keys", roleOf(1), roleOf(3), keys())
+	}
+
+	if code := post(users["lead"], url.Values{"action": {"set_member_role"}, "user_id": {"3"}, "role": {"lead"}}); code != http.StatusSeeOther || roleOf(3) != "lead" {
+		t.Errorf("lead granting its own role: %d, role %q", code, roleOf(3))
+	}
+	if code := post(users["client"], url.Values{"action": {"create_api_key"}}); code != http.StatusForbidden {
+		t.Errorf("client reached settings: %d", code)
+	}
+	if code := post(users["owner"], url.Values{"action": {"create_api_key"}}); code != http.StatusOK || keys() != 1 {
+		t.Errorf("owner creating an API key: %d, %d keys", code, keys())
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// relationKinds are the relations as seen from one issue. Each is stored as
+// a row of issue_relations with a canonical type; the inverse kinds swap
+// source and target.
+var relationKinds = []string{"blocks", "blocked_by", "relates", "duplicates", "duplicated_by"}
+
+func relationStorage(kind string) (relType string, inverse bool, ok bool) {
+	switch kind {
+	case "blocks", "relates", "duplicates":
+		return kind, false, true
+	case "blocked_by":
+		return "blocks", true, true
+	case "duplicated_by":
+		return "duplicates", true, true
+	}
+	return "", false, false
+}
+
+// issueRelations lists the relations of an issue from its point of view.
+func issueRelations(issueID int64) []IssueRelation {
+	rows, err := db.Query(`
+		SELECT r.id, r.type, r.source_id = ?, o.id, COALESCE(o.number, 0), pr.issue_prefix, o.title, o.status
+		FROM issue_relations r
+		JOIN issues o ON o.id = CASE WHEN r.source_id = ? THEN r.target_id ELSE r.source_id END
+		JOIN projects pr ON pr.id = o.project_id
+		WHERE r.source_id = ? OR r.target_id = ?
+		ORDER BY r.type, o.id`, issueID, issueID, issueID, issueID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var rels []IssueRelation
+	for rows.Next() {
+		var rel IssueRelation
+		var relType string
+		var outgoing bool
+		var prefix string
+		other := &Issue{}
+		rows.Scan(&rel.ID, &relType, &outgoing, &other.ID, &other.Number, &prefix, &other.Title, &other.Status)
+		other.Key = issueKey(prefix, other.Number)
+		rel.Kind = relType
+		if !outgoing {
+			switch relType {
+			case "blocks":
+				rel.Kind = "blocked_by"
+			case "duplicates":
+				rel.Kind = "duplicated_by"
+			}
+		}
+		rel.Issue = other
+		rels = append(rels, rel)
+	}
+	return rels
+}
+
+// addIssueRelation links two issues of the same project. It reports whether
+// the relation is valid; adding one that already exists is a no-op.
+func addIssueRelation(projectID, issueID int64, kind string, otherID int64, createdBy *int64) bool {
+	relType, inverse, ok := relationStorage(kind)
+	if !ok || issueID == otherID {
+		return false
+	}
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM issues WHERE project_id = ? AND id IN (?, ?)", projectID, issueID, otherID).Scan(&n)
+	if n != 2 {
+		return false
+	}
+	source, target := issueID, otherID
+	if inverse || (relType == "relates" && otherID < issueID) {
+		source, target = otherID, issueID
+	}
+	db.Exec(`INSERT OR IGNORE INTO issue_relations (project_id, source_id, target_id, type, created_by)
+		VALUES (?, ?, ?, ?, ?)`, projectID, source, target, relType, createdBy)
+	return true
+}
+
+// openBlockers maps each issue of a project to the titles of the issues that
+// block it and aren't done yet.
+func openBlockers(projectID int64) map[int64][]string {
+	rows, err := db.Query(`
+		SELECT r.target_id, b.title
+		FROM issue_relations r
+		JOIN issues b ON b.id = r.source_id
+		WHERE r.project_id = ? AND r.type = 'blocks' AND NOT `+statusDoneSQL("b")+`
+		ORDER BY b.id`, projectID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	blockers := map[int64][]string{}
+	for rows.Next() {
+		var id int64
+		var title string
+		rows.Scan(&id, &title)
+		blockers[id] = append(blockers[id], title)
+	}
+	return blockers
+}
+
+func handleAddRelation(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	p, _, ok := authorizeProject(w, r, permIssuesEdit)
+	if !ok {
+		return
+	}
+	id := resolveIssueID(p.ID, r.PathValue("id"))
+	otherID := resolveIssueID(p.ID, r.FormValue("issue_key"))
+	kind := r.FormValue("kind")
+	if !addIssueRelation(p.ID, id, kind, otherID, &u.ID) {
+		http.Error(w, "Invalid relation", http.StatusBadRequest)
+		return
+	}
+	recordChange(r, u, p.ID, "issue.relation.add", relationTarget(id, kind, otherID), nil, nil)
+	http.Redirect(w, r, issuePath(p, id)+"#relations", http.StatusSeeOther)
+}
+
+func handleDeleteRelation(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	p, _, ok := authorizeProject(w, r, permIssuesEdit)
+	if !ok {
+		return
+	}
+	id := resolveIssueID(p.ID, r.PathValue("id"))
+	if desc, ok := deleteIssueRelation(p.ID, id, r.PathValue("rid")); ok {
+		recordChange(r, u, p.ID, "issue.relation.remove", desc, nil, nil)
+	}
+	http.Redirect(w, r, issuePath(p, id)+"#relations", http.StatusSeeOther)
+}
+
+// deleteIssueRelation removes a relation of issueID and describes it for the
+// audit log.
+func deleteIssueRelation(projectID, issueID int64, relationID string) (string, bool) {
+	var source, target int64
+	var relType string
+	err := db.QueryRow(`SELECT source_id, target_id, type FROM issue_relations
+		WHERE id = ? AND project_id = ? AND (source_id = ? OR target_id = ?)`,
+		relationID, projectID, issueID, issueID).Scan(&source, &target, &relType)
+	if err != nil {
+		return "", false
+	}
+	db.Exec("DELETE FROM issue_relations WHERE id = ?", relationID)
+	return relationTarget(source, relType, target), true
+}
+
+// relationTarget reads like "#12 blocked_by #7".
+func relationTarget(issueID int64, kind string, otherID int64) string {
+	return fmt.Sprintf("#%d %s #%d", issueID, kind, otherID)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// searchEnabled is false when SQLite was built without FTS5 (the
+// sqlite_fts5 build tag), in which case search pages say so.
+var searchEnabled bool
+
+// search_index rows encode their source in the rowid as id*8 + kind, so the
+// triggers below can replace or remove exactly one row per change.
+var searchTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS search_issues_ai AFTER INSERT ON issues BEGIN
+		INSERT INTO search_index (rowid, title, body, kind, project_id, ref)
+		VALUES (new.id * 8 + 1, new.title, new.description, 'issue', new.project_id, new.id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_issues_au AFTER UPDATE OF title, description ON issues BEGIN
+		DELETE FROM search_index WHERE rowid = old.id * 8 + 1;
+		INSERT INTO search_index (rowid, title, body, kind, project_id, ref)
+		VALUES (new.id * 8 + 1, new.title, new.description, 'issue', new.project_id, new.id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_issues_ad AFTER DELETE ON issues BEGIN
+		DELETE FROM search_index WHERE rowid = old.id * 8 + 1;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_messages_ai AFTER INSERT ON messages BEGIN
+		INSERT INTO search_index (rowid, title, body, kind, project_id, ref)
+		VALUES (new.id * 8 + 2, '', new.content, 'message', new.project_id, new.id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_messages_ad AFTER DELETE ON messages BEGIN
+		DELETE FROM search_index WHERE rowid = old.id * 8 + 2;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_files_ai AFTER INSERT ON files BEGIN
+		INSERT INTO search_index (rowid, title, body, kind, project_id, ref)
+		VALUES (new.id * 8 + 3, new.name, '', 'file', new.project_id, new.id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_files_au AFTER UPDATE OF name ON files BEGIN
+		DELETE FROM search_index WHERE rowid = old.id * 8 + 3;
+		INSERT INTO search_index (rowid, title, body, kind, project_id, ref)
+		VALUES (new.id * 8 + 3, new.name, '', 'file', new.project_id, new.id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_files_ad AFTER DELETE ON files BEGIN
+		DELETE FROM search_index WHERE rowid = old.id * 8 + 3;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_docs_au AFTER UPDATE OF status_md, roadmap_md ON projects BEGIN
+		DELETE FROM search_index WHERE rowid IN (old.id * 8 + 4, old.id * 8 + 5);
+		INSERT INTO search_index (rowid, title, body, kind, project_id, ref)
+		SELECT new.id * 8 + 4, '', new.status_md, 'status', new.id, new.id WHERE new.status_md != '';
+		INSERT INTO search_index (rowid, title, body, kind, project_id, ref)
+		SELECT new.id * 8 + 5, '', new.roadmap_md, 'roadmap', new.id, new.id WHERE new.roadmap_md != '';
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_docs_ad AFTER DELETE ON projects BEGIN
+		DELETE FROM search_index WHERE rowid IN (old.id * 8 + 4, old.id * 8 + 5);
+	END`,
+}
+
+var searchTriggerNames = []string{
+	"search_issues_ai", "search_issues_au", "search_issues_ad",
+	"search_messages_ai", "search_messages_ad",
+	"search_files_ai", "search_files_au", "search_files_ad",
+	"search_docs_au", "search_docs_ad",
+}
+
+// searchReindex fills the index from scratch, for databases that had content
+// before search existed.
+var searchReindex = []string{
+	"DELETE FROM search_index",
+	`INSERT INTO search_index (rowid, title, body, kind, project_id, ref)
+		SELECT id * 8 + 1, title, description, 'issue', project_id, id FROM issues`,
+	`INSERT INTO search_index (rowid, title, body, kind, project_id, ref)
+		SELECT id * 8 + 2, '', content, 'message', project_id, id FROM messages`,
+	`INSERT INTO search_index (rowid, title, body, kind, project_id, ref)
+		SELECT id * 8 + 3, name, '', 'file', project_id, id FROM files`,
+	`INSERT INTO search_index (rowid, title, body, kind, project_id, ref)
+		SELECT id * 8 + 4, '', status_md, 'status', id, id FROM projects WHERE status_md != ''`,
+	`INSERT INTO search_index (rowid, title, body, kind, project_id, ref)
+		SELECT id * 8 + 5, '', roadmap_md, 'roadmap', id, id FROM projects WHERE roadmap_md != ''`,
+}
+
+// searchMatch turns what the user typed into an FTS5 query: every word must
+// appear, as a prefix, and operators are taken literally.
+func searchMatch(q string) string {
+	var terms []string
+	for _, w := range strings.Fields(q) {
+		w = strings.ReplaceAll(w, `"`, "")
+		if w != "" {
+			terms = append(terms, `"`+w+`"*`)
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// searchHighlight escapes FTS5 output and turns its match markers into
+// <mark> elements.
+func searchHighlight(s string) template.HTML {
+	s = template.HTMLEscapeString(s)
+	s = strings.ReplaceAll(s, "\x02", "<mark>")
+	s = strings.ReplaceAll(s, "\x03", "</mark>")
+	return template.HTML(s)
+}
+
+// searchProjects lists what q matches in the given projects, best first. An
+// exact issue key goes on top.
+func searchProjects(projects []Project, q string, limit int) []SearchResult {
+	match := searchMatch(q)
+	if !searchEnabled || match == "" || len(projects) == 0 {
+		return nil
+	}
+	byID := map[int64]*Project{}
+	ids := make([]string, len(projects))
+	for i := range projects {
+		byID[projects[i].ID] = &projects[i]
+		ids[i] = strconv.FormatInt(projects[i].ID, 10)
+	}
+
+	var results []SearchResult
+	if m := issueKeyRe.FindStringSubmatch(strings.TrimSpace(q)); m != nil {
+		var r SearchResult
+		var projectID int64
+		var title string
+		err := db.QueryRow(`SELECT i.id, i.project_id, i.number, i.title FROM issues i
+			JOIN projects p ON p.id = i.project_id
+			WHERE p.issue_prefix = ? AND i.number = ?`, strings.ToUpper(m[1]), m[2]).
+			Scan(&r.Ref, &projectID, &r.Number, &title)
+		if p := byID[projectID]; err == nil && p != nil {
+			r.Kind, r.Project = "issue", p
+			r.TitleHTML = searchHighlight(title)
+			results = append(results, r)
+		}
+	}
+
+	rows, err := db.Query(`
+		SELECT s.kind, s.ref, s.project_id, s.title, s.snippet, COALESCE(i.number, 0)
+		FROM (
+			SELECT kind, ref, project_id, rank,
+				highlight(search_index, 0, char(2), char(3)) AS title,
+				snippet(search_index, 1, char(2), char(3), '…', 16) AS snippet
+			FROM search_index
+			WHERE search_index MATCH ? AND CAST(project_id AS INTEGER) IN (`+strings.Join(ids, ", ")+`)
+			ORDER BY rank
+			LIMIT ?
+		) s
+		LEFT JOIN issues i ON s.kind = 'issue' AND i.id = s.ref
+		ORDER BY s.rank`, match, limit)
+	if err != nil {
+		return results
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r SearchResult
+		var projectID int64
+		var title, snippet string
+		rows.Scan(&r.Kind, &r.Ref, &projectID, &title, &snippet, &r.Number)
+		if len(results) > 0 && results[0].Kind == r.Kind && results[0].Ref == r.Ref {
+			continue
+		}
+		r.Project = byID[projectID]
+		r.TitleHTML = searchHighlight(title)
+		r.Snippet = searchHighlight(snippet)
+		results = append(results, r)
+	}
+	return results
+}
+
+// URL is where a search result opens.
+func (r SearchResult) URL() string {
+	base := "/projects/" + r.Project.Slug
+	switch r.Kind {
+	case "issue":
+		return base + "/issues/" + issueKey(r.Project.IssuePrefix, r.Number)
+	case "file":
+		return base + "/files/" + strconv.FormatInt(r.Ref, 10) + "/download"
+	case "status", "roadmap":
+		return base + "/docs/" + r.Kind
+	}
+	return base
+}
+
+// searchScope is the projects a user may search: those getProjectForUser
+// lets them open, or only slug when given.
+func searchScope(u *User, slug string) []Project {
+	if slug != "" {
+		p, _ := getProjectForUser(slug, u)
+		if p == nil {
+			return nil
+		}
+		return []Project{*p}
+	}
+	var projects []Project
+	for _, p := range userProjects(u) {
+		if vp, _ := getProjectForUser(p.Slug, u); vp != nil {
+			projects = append(projects, *vp)
+		}
+	}
+	return projects
+}
+
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	slug := r.URL.Query().Get("project")
+	renderTemplate(w, "search.html", map[string]any{
+		"User":          u,
+		"Projects":      userProjects(u),
+		"Query":         q,
+		"ProjectFilter": slug,
+		"Results":       searchProjects(searchScope(u, slug), q, 50),
+		"Enabled":       searchEnabled,
+	})
+}
+
+// handleAPISearch searches what the caller can read: a project key its own
+// project, a personal token its owner's projects.
+func handleAPISearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !searchEnabled {
+		http.Error(w, `{"error":"search is not available"}`, http.StatusServiceUnavailable)
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	slug := r.URL.Query().Get("project")
+	var projects []Project
+	if u := currentUser(r); u != nil {
+		projects = searchScope(u, slug)
+	} else {
+		var p Project
+		db.QueryRow("SELECT id, name, slug, issue_prefix FROM projects WHERE id = ?", r.Context().Value(apiProjectIDKey).(int64)).
+			Scan(&p.ID, &p.Name, &p.Slug, &p.IssuePrefix)
+		if slug == "" || slug == p.Slug {
+			projects = []Project{p}
+		}
+	}
+	results := []map[string]any{}
+	for _, res := range searchProjects(projects, q, 50) {
+		item := map[string]any{
+			"kind": res.Kind, "project": res.Project.Slug, "title": string(res.TitleHTML),
+			"snippet": string(res.Snippet), "url": cfg.BaseURL + res.URL(),
+		}
+		if res.Kind == "issue" {
+			item["key"] = issueKey(res.Project.IssuePrefix, res.Number)
+		}
+		results = append(results, item)
+	}
+	json.NewEncoder(w).Encode(map[string]any{"query": q, "results": results})
+}
+
+// handleProjectDoc shows the status or roadmap document pushed through the
+// API, which search results link to.
+func handleProjectDoc(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	p, role := getProjectForUser(r.PathValue("slug"), u)
+	doc := r.PathValue("doc")
+	if p == nil || (doc != "status" && doc != "roadmap") {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	var body string
+	db.QueryRow("SELECT "+doc+"_md FROM projects WHERE id = ?", p.ID).Scan(&body)
+	renderTemplate(w, "project_doc.html", map[string]any{
+		"User":     u,
+		"Project":  p,
+		"Projects": userProjects(u),
+		"Access":   projectAccess(p.ID, u, role),
+		"Doc":      doc,
+		"Body":     body,
+	})
+}
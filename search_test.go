@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSearchMatch(t *testing.T) {
+	for q, want := range map[string]string{
+		"login":               `"login"*`,
+		"  login   roto ":     `"login"* "roto"*`,
+		`"a OR b" NEAR(c) -d`: `"a"* "OR"* "b"* "NEAR(c)"* "-d"*`,
+		`""`:                  "",
+		"":                    "",
+	} {
+		if got := searchMatch(q); got != want {
+			t.Errorf("searchMatch(%q) = %s, want %s", q, got, want)
+		}
+	}
+}
+
+func TestSearchHighlight(t *testing.T) {
+	got := string(searchHighlight("<b>\x02log\x03in</b>"))
+	if want := "&lt;b&gt;<mark>log</mark>in&lt;/b&gt;"; got != want {
+		t.Errorf("searchHighlight = %s, want %s", got, want)
+	}
+}
+
+func TestSearchProjects(t *testing.T) {
+	openTestDB(t)
+	if !searchEnabled {
+		t.Skip("SQLite built without FTS5; run with -tags sqlite_fts5")
+	}
+	users := seedProject(t)
+	mustExec(t,
+		"INSERT INTO projects (id, name, slug, issue_prefix) VALUES (2, 'Beta', 'beta', 'BETA')",
+		"UPDATE projects SET status_md = 'Migración del login pendiente' WHERE id = 2",
+		"INSERT INTO project_members (project_id, user_id, role) VALUES (2, 6, 'member')",
+		`INSERT INTO issues (id, project_id, number, title, description, status) VALUES
+			(1, 1, 1, 'Login roto', '', 'todo'),
+			(2, 1, 2, 'Exportar', 'CSV', 'todo'),
+			(3, 2, 7, 'Login de Beta', '', 'todo')`,
+		"INSERT INTO messages (project_id, user_id, content) VALUES (1, 1, 'el login vuelve a fallar')",
+		"INSERT INTO files (project_id, name, path, size, uploaded_by) VALUES (1, 'login-flow.pdf', 'x', 1, 1)",
+	)
+	kinds := func(results []SearchResult) map[string]int {
+		n := map[string]int{}
+		for _, r := range results {
+			n[r.Project.Slug+" "+r.Kind]++
+		}
+		return n
+	}
+
+	got := kinds(searchProjects(searchScope(users["member"], ""), "log", 50))
+	if got["acme issue"] != 1 || got["acme message"] != 1 || got["acme file"] != 1 || got["beta issue"] != 0 || got["beta status"] != 0 {
+		t.Errorf("member searching log: %v", got)
+	}
+	if got := kinds(searchProjects(searchScope(users["none"], ""), "login", 50)); got["beta issue"] != 1 || got["beta status"] != 1 || len(got) != 2 {
+		t.Errorf("beta member searching login: %v", got)
+	}
+	if r := searchProjects(searchScope(users["none"], "acme"), "login", 50); r != nil {
+		t.Errorf("scoped to a project the user can't open: %v", r)
+	}
+
+	results := searchProjects(searchScope(users["member"], "acme"), "acme-2", 50)
+	if len(results) == 0 || results[0].Ref != 2 || results[0].URL() != "/projects/acme/issues/ACME-2" {
+		t.Errorf("issue key search: %+v", results)
+	}
+	if r := searchProjects(searchScope(users["member"], ""), "BETA-7", 50); len(r) != 0 {
+		t.Errorf("key of another project's issue found: %+v", r)
+	}
+
+	mustExec(t, "UPDATE issues SET description = 'Falla con <script>' WHERE id = 1")
+	results = searchProjects(searchScope(users["member"], "acme"), "falla script", 50)
+	if len(results) != 1 || string(results[0].Snippet) != "<mark>Falla</mark> con &lt;<mark>script</mark>&gt;" {
+		t.Errorf("highlighted snippet: %+v", results)
+	}
+	mustExec(t, "DELETE FROM issues WHERE id = 1")
+	if r := searchProjects(searchScope(users["member"], "acme"), "roto", 50); len(r) != 0 {
+		t.Errorf("deleted issue still found: %+v", r)
+	}
+}
@@ -1,3 +1,25 @@
+// CSRF: attach the session token to HTMX requests, fetch calls and form posts
+function csrfToken() {
+    var meta = document.querySelector('meta[name="csrf-token"]');
+    return meta ? meta.content : '';
+}
+
+document.addEventListener('htmx:configRequest', function(e) {
+    var token = csrfToken();
+    if (token) e.detail.headers['X-CSRF-Token'] = token;
+});
+
+document.addEventListener('submit', function(e) {
+    var form = e.target;
+    var token = csrfToken();
+    if (!token || form.method.toLowerCase() !== 'post' || form.elements.csrf_token) return;
+    var input = document.createElement('input');
+    input.type = 'hidden';
+    input.name = 'csrf_token';
+    input.value = token;
+    form.appendChild(input);
+}, true);
+
 // Tab switching
 function switchTab(tabName) {
     var url = new URL(window.location);
@@ -30,6 +52,72 @@ document.addEventListener('DOMContentLoaded', function() {
     });
 });
 
+// Blocked issues: ask before starting work on them, i.e. moving them to a
+// status of the active category. el carries the titles of the unfinished
+// blockers in data-blocked and the current status in data-status, which is
+// restored if the user cancels.
+function confirmBlocked(el, category) {
+    if (category !== 'active' || !el.dataset.blocked) return true;
+    if (confirm('Esta tarea está bloqueada por: ' + el.dataset.blocked + '. ¿Empezarla igualmente?')) return true;
+    if (el.dataset.status) el.value = el.dataset.status;
+    return false;
+}
+
+document.addEventListener('htmx:confirm', function(e) {
+    var el = e.detail.elt;
+    if (!el.dataset || !el.dataset.blocked || !el.selectedOptions) return;
+    var category = el.selectedOptions[0].dataset.category;
+    if (category !== 'active') return;
+    e.preventDefault();
+    if (confirmBlocked(el, category)) e.detail.issueRequest(true);
+});
+
+// Kanban board: dragging a card saves its status and position
+function initBoard(root) {
+    var board = root.querySelector('.board[data-reorder-url]');
+    if (!board || typeof Sortable === 'undefined') return;
+    board.querySelectorAll('.board-cards').forEach(function(col) {
+        Sortable.create(col, {
+            group: 'board',
+            animation: 150,
+            onEnd: function(e) {
+                if (e.from !== e.to && !confirmBlocked(e.item, e.to.dataset.category)) {
+                    e.from.insertBefore(e.item, e.from.children[e.oldIndex] || null);
+                    return;
+                }
+                var order = Array.prototype.map.call(e.to.querySelectorAll('.board-card'), function(c) {
+                    return c.dataset.id;
+                });
+                fetch(board.dataset.reorderUrl, {
+                    method: 'POST',
+                    headers: {'X-CSRF-Token': csrfToken()},
+                    credentials: 'same-origin',
+                    body: new URLSearchParams({
+                        issue_id: e.item.dataset.id,
+                        status: e.to.dataset.status,
+                        order: order.join(',')
+                    })
+                }).then(function(r) {
+                    if (!r.ok) throw new Error(r.statusText);
+                    board.querySelectorAll('.board-column').forEach(function(c) {
+                        c.querySelector('.board-count').textContent = c.querySelectorAll('.board-card').length;
+                    });
+                }).catch(function() {
+                    window.location.reload();
+                });
+            }
+        });
+    });
+}
+
+document.addEventListener('DOMContentLoaded', function() {
+    initBoard(document);
+});
+
+document.addEventListener('htmx:afterSwap', function(e) {
+    initBoard(e.detail.target);
+});
+
 // Image carousel
 var carouselIndex = 0;
 
@@ -52,3 +140,105 @@ function carouselGo(idx) {
         d.classList.toggle('active', i === idx);
     });
 }
+
+// Passkeys (WebAuthn)
+function b64urlToBuf(s) {
+    s = s.replace(/-/g, '+').replace(/_/g, '/');
+    while (s.length % 4) s += '=';
+    var bin = atob(s);
+    var buf = new Uint8Array(bin.length);
+    for (var i = 0; i < bin.length; i++) buf[i] = bin.charCodeAt(i);
+    return buf.buffer;
+}
+
+function bufToB64url(buf) {
+    var bytes = new Uint8Array(buf);
+    var bin = '';
+    for (var i = 0; i < bytes.length; i++) bin += String.fromCharCode(bytes[i]);
+    return btoa(bin).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+}
+
+function passkeyError(msg) {
+    var el = document.getElementById('passkey-error');
+    if (!el) return;
+    el.textContent = msg;
+    el.hidden = !msg;
+}
+
+function passkeyPost(url, body) {
+    return fetch(url, {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken()},
+        credentials: 'same-origin',
+        body: body ? JSON.stringify(body) : null
+    }).then(function(r) {
+        return r.json().then(function(data) {
+            if (!r.ok) throw new Error(data.error || 'Error');
+            return data;
+        });
+    });
+}
+
+function passkeyLogin() {
+    passkeyError('');
+    passkeyPost('/auth/passkey/begin').then(function(opts) {
+        var pk = opts.publicKey;
+        pk.challenge = b64urlToBuf(pk.challenge);
+        (pk.allowCredentials || []).forEach(function(c) { c.id = b64urlToBuf(c.id); });
+        return navigator.credentials.get({publicKey: pk});
+    }).then(function(cred) {
+        return passkeyPost('/auth/passkey/finish', {
+            id: cred.id,
+            rawId: bufToB64url(cred.rawId),
+            type: cred.type,
+            response: {
+                authenticatorData: bufToB64url(cred.response.authenticatorData),
+                clientDataJSON: bufToB64url(cred.response.clientDataJSON),
+                signature: bufToB64url(cred.response.signature),
+                userHandle: cred.response.userHandle ? bufToB64url(cred.response.userHandle) : null
+            }
+        });
+    }).then(function(res) {
+        window.location.href = res.redirect || '/dashboard';
+    }).catch(function(e) {
+        if (e.name === 'NotAllowedError') return;
+        passkeyError(e.message);
+    });
+}
+
+function passkeyRegister(e) {
+    e.preventDefault();
+    passkeyError('');
+    var name = e.target.elements.name.value;
+    passkeyPost('/account/passkeys/begin').then(function(opts) {
+        var pk = opts.publicKey;
+        pk.challenge = b64urlToBuf(pk.challenge);
+        pk.user.id = b64urlToBuf(pk.user.id);
+        (pk.excludeCredentials || []).forEach(function(c) { c.id = b64urlToBuf(c.id); });
+        return navigator.credentials.create({publicKey: pk});
+    }).then(function(cred) {
+        return passkeyPost('/account/passkeys/finish?name=' + encodeURIComponent(name), {
+            id: cred.id,
+            rawId: bufToB64url(cred.rawId),
+            type: cred.type,
+            response: {
+                attestationObject: bufToB64url(cred.response.attestationObject),
+                clientDataJSON: bufToB64url(cred.response.clientDataJSON),
+                transports: cred.response.getTransports ? cred.response.getTransports() : []
+            }
+        });
+    }).then(function() {
+        window.location.reload();
+    }).catch(function(e) {
+        if (e.name === 'NotAllowedError') return;
+        passkeyError(e.message);
+    });
+}
+
+document.addEventListener('DOMContentLoaded', function() {
+    var btn = document.getElementById('passkey-login');
+    if (btn && window.PublicKeyCredential) {
+        btn.hidden = false;
+        document.getElementById('auth-alternatives').hidden = false;
+    }
+});
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+)
+
+// defaultStatuses is the workflow new projects start with, and the one
+// existing projects got when statuses became configurable.
+var defaultStatuses = []IssueStatus{
+	{Key: "backlog", Name: "Backlog", Category: "open"},
+	{Key: "todo", Name: "Por hacer", Category: "open"},
+	{Key: "in_progress", Name: "En progreso", Category: "active"},
+	{Key: "review", Name: "Revisión", Category: "active"},
+	{Key: "done", Name: "Hecho", Category: "done"},
+}
+
+// statusCategories group statuses for reporting: milestone progress counts
+// issues in a done status, and moving a blocked issue to an active one asks
+// for confirmation.
+var statusCategories = []string{"open", "active", "done"}
+
+func validStatusCategory(c string) bool {
+	for _, sc := range statusCategories {
+		if sc == c {
+			return true
+		}
+	}
+	return false
+}
+
+// statusDoneSQL is a condition that is true when the issue aliased as alias
+// is in a status of the done category.
+func statusDoneSQL(alias string) string {
+	return "EXISTS (SELECT 1 FROM project_statuses s WHERE s.project_id = " + alias + ".project_id AND s.key = " +
+		alias + ".status AND s.category = 'done')"
+}
+
+func seedProjectStatuses(projectID int64) {
+	for i, s := range defaultStatuses {
+		db.Exec("INSERT OR IGNORE INTO project_statuses (project_id, key, name, category, position) VALUES (?, ?, ?, ?, ?)",
+			projectID, s.Key, s.Name, s.Category, i+1)
+	}
+}
+
+// projectStatuses lists the workflow of a project in order, with how many
+// issues are in each status.
+func projectStatuses(projectID int64) []IssueStatus {
+	rows, err := db.Query(`
+		SELECT s.id, s.project_id, s.key, s.name, s.category, s.position,
+			(SELECT COUNT(*) FROM issues i WHERE i.project_id = s.project_id AND i.status = s.key)
+		FROM project_statuses s
+		WHERE s.project_id = ?
+		ORDER BY s.position, s.id`, projectID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var statuses []IssueStatus
+	for rows.Next() {
+		var s IssueStatus
+		rows.Scan(&s.ID, &s.ProjectID, &s.Key, &s.Name, &s.Category, &s.Position, &s.Issues)
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+// renumberStatuses stores statuses, the whole workflow of the project, in
+// the given order as positions 1..n. Deleting statuses leaves gaps, so
+// moving one always rewrites them all, in one transaction.
+func renumberStatuses(projectID int64, statuses []IssueStatus) bool {
+	tx, err := db.Begin()
+	if err != nil {
+		return false
+	}
+	defer tx.Rollback()
+	for n, s := range statuses {
+		if _, err := tx.Exec("UPDATE project_statuses SET position = ? WHERE id = ? AND project_id = ?", n+1, s.ID, projectID); err != nil {
+			return false
+		}
+	}
+	return tx.Commit() == nil
+}
+
+// statusOf finds key among statuses. Unknown keys, such as statuses deleted
+// after being recorded in the history, show as themselves.
+func statusOf(statuses []IssueStatus, key string) IssueStatus {
+	for _, s := range statuses {
+		if s.Key == key {
+			return s
+		}
+	}
+	return IssueStatus{Key: key, Name: key, Category: "open"}
+}
+
+func validStatus(projectID int64, key string) bool {
+	var exists bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM project_statuses WHERE project_id = ? AND key = ?)", projectID, key).Scan(&exists)
+	return exists
+}
+
+// firstStatus is where new issues go when no status is given.
+func firstStatus(projectID int64) string {
+	var key string
+	db.QueryRow("SELECT key FROM project_statuses WHERE project_id = ? ORDER BY position, id LIMIT 1", projectID).Scan(&key)
+	return key
+}
+
+// statusKey derives the stored key of a new status from its name.
+func statusKey(name string) string {
+	return strings.ReplaceAll(makeSlug(name), "-", "_")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func statusKeys() []string {
+	var keys []string
+	for _, s := range projectStatuses(1) {
+		keys = append(keys, s.Key)
+	}
+	return keys
+}
+
+func TestMoveStatusAfterDelete(t *testing.T) {
+	openTestDB(t)
+	initTemplates()
+	users := seedProject(t)
+	seedProjectStatuses(1)
+	settings := func(form url.Values) {
+		postAs(handleProjectSettings, users["owner"], form, "slug", "acme")
+	}
+	idOf := func(key string) string {
+		for _, s := range projectStatuses(1) {
+			if s.Key == key {
+				return strconv.FormatInt(s.ID, 10)
+			}
+		}
+		t.Fatalf("no status %s", key)
+		return ""
+	}
+
+	// The rest stay at positions 3, 4 and 5.
+	settings(url.Values{"action": {"delete_status"}, "status_id": {idOf("backlog")}})
+	settings(url.Values{"action": {"delete_status"}, "status_id": {idOf("todo")}})
+	settings(url.Values{"action": {"move_status"}, "status_id": {idOf("review")}, "direction": {"down"}})
+	if got := statusKeys(); len(got) != 3 || got[0] != "in_progress" || got[1] != "done" || got[2] != "review" {
+		t.Fatalf("after moving review down: %v", got)
+	}
+	settings(url.Values{"action": {"move_status"}, "status_id": {idOf("in_progress")}, "direction": {"up"}})
+	settings(url.Values{"action": {"move_status"}, "status_id": {idOf("review")}, "direction": {"up"}})
+	if got := statusKeys(); got[0] != "in_progress" || got[1] != "review" || got[2] != "done" {
+		t.Errorf("after moving review up: %v", got)
+	}
+	for n, s := range projectStatuses(1) {
+		if s.Position != n+1 {
+			t.Errorf("%s at position %d, want %d", s.Key, s.Position, n+1)
+		}
+	}
+}
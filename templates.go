@@ -6,6 +6,7 @@ import (
 	"html/template"
 	"net/http"
 	"strings"
+	"time"
 )
 
 //go:embed templates/*
@@ -26,31 +27,31 @@ var funcMap = template.FuncMap{
 	"hasPrefix":  strings.HasPrefix,
 	"hasSuffix":  strings.HasSuffix,
 	"formatSize": formatSize,
-	"statusLabel": func(s string) string {
+	"roleLabel": func(s string) string {
 		labels := map[string]string{
-			"backlog":     "Backlog",
-			"todo":        "Por hacer",
-			"in_progress": "En progreso",
-			"review":      "Revisión",
-			"done":        "Hecho",
+			"owner":  "Propietario",
+			"member": "Miembro",
+			"client": "Cliente",
 		}
 		if l, ok := labels[s]; ok {
 			return l
 		}
 		return s
 	},
-	"priorityLabel": func(s string) string {
+	"statusOf":      statusOf,
+	"linkIssueKeys": linkIssueKeys,
+	"statusCategoryLabel": func(s string) string {
 		labels := map[string]string{
-			"low":    "Baja",
-			"medium": "Media",
-			"high":   "Alta",
-			"urgent": "Urgente",
+			"open":   "Pendiente",
+			"active": "En curso",
+			"done":   "Terminado",
 		}
 		if l, ok := labels[s]; ok {
 			return l
 		}
 		return s
 	},
+	"priorityLabel": priorityLabel,
 	"eq": func(a, b any) bool {
 		return fmt.Sprintf("%v", a) == fmt.Sprintf("%v", b)
 	},
@@ -70,11 +71,11 @@ var funcMap = template.FuncMap{
 	"sub": func(a, b int) int {
 		return a - b
 	},
-	"milestoneProgress": func(total, done int) int {
+	"percent": func(part, total int) int {
 		if total == 0 {
 			return 0
 		}
-		return done * 100 / total
+		return part * 100 / total
 	},
 	"progressCircle": func(pct int) template.HTML {
 		r := 36.0
@@ -96,13 +97,108 @@ var funcMap = template.FuncMap{
 	"isImage": func(mime string) bool {
 		return strings.HasPrefix(mime, "image/")
 	},
-	"split": strings.Split,
+	"split":   strings.Split,
+	"maskKey": maskKey,
 	"derefStr": func(p *string) string {
 		if p == nil {
 			return ""
 		}
 		return *p
 	},
+	"initial": func(name string) string {
+		for _, r := range name {
+			return strings.ToUpper(string(r))
+		}
+		return "?"
+	},
+	"localTime": localTime,
+	"markdown":  renderMarkdown,
+	"duration":  formatDuration,
+	"issueKey":  issueKey,
+	"searchKindLabel": func(s string) string {
+		labels := map[string]string{
+			"issue":   "Tarea",
+			"message": "Mensaje",
+			"file":    "Archivo",
+			"status":  "Estado",
+			"roadmap": "Roadmap",
+		}
+		if l, ok := labels[s]; ok {
+			return l
+		}
+		return s
+	},
+	"relationLabel": func(s string) string {
+		labels := map[string]string{
+			"blocks":        "Bloquea a",
+			"blocked_by":    "Bloqueada por",
+			"relates":       "Relacionada con",
+			"duplicates":    "Duplica a",
+			"duplicated_by": "Duplicada por",
+		}
+		if l, ok := labels[s]; ok {
+			return l
+		}
+		return s
+	},
+	"fieldLabel": func(s string) string {
+		labels := map[string]string{
+			"status":       "el estado",
+			"priority":     "la prioridad",
+			"title":        "el título",
+			"description":  "la descripción",
+			"assignee_id":  "el responsable",
+			"due_date":     "la fecha límite",
+			"milestone_id": "el hito",
+			"labels":       "las etiquetas",
+		}
+		if l, ok := labels[s]; ok {
+			return l
+		}
+		if name, ok := strings.CutPrefix(s, "custom:"); ok {
+			return "el campo " + name
+		}
+		return s
+	},
+	"customFieldTypeLabel": func(s string) string {
+		labels := map[string]string{
+			"text":   "Texto",
+			"number": "Número",
+			"date":   "Fecha",
+			"select": "Lista",
+			"user":   "Persona",
+		}
+		if l, ok := labels[s]; ok {
+			return l
+		}
+		return s
+	},
+}
+
+// priorityLabel names a priority in the UI and in exports.
+func priorityLabel(s string) string {
+	labels := map[string]string{
+		"low":    "Baja",
+		"medium": "Media",
+		"high":   "Alta",
+		"urgent": "Urgente",
+	}
+	if l, ok := labels[s]; ok {
+		return l
+	}
+	return s
+}
+
+// localTime converts t to the viewer's preferred timezone.
+func localTime(u *User, t time.Time) time.Time {
+	if u == nil || u.Timezone == "" {
+		return t.Local()
+	}
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return t.Local()
+	}
+	return t.In(loc)
 }
 
 func mustParsePage(files ...string) *template.Template {
@@ -114,13 +210,24 @@ func initTemplates() {
 
 	templates = map[string]*template.Template{
 		"dashboard.html":        mustParsePage(append(shared, "templates/dashboard.html")...),
-		"project.html":          mustParsePage(append(shared, "templates/project.html", "templates/issues_tab.html", "templates/files_tab.html", "templates/milestones_tab.html")...),
+		"project.html":          mustParsePage(append(shared, "templates/project.html", "templates/issues_tab.html", "templates/board_tab.html", "templates/files_tab.html", "templates/milestones_tab.html")...),
 		"project_settings.html": mustParsePage(append(shared, "templates/project_settings.html")...),
+		"account.html":          mustParsePage(append(shared, "templates/account.html")...),
+		"admin_users.html":      mustParsePage(append(shared, "templates/admin_users.html")...),
+		"admin_domains.html":    mustParsePage(append(shared, "templates/admin_domains.html")...),
+		"admin_scim.html":       mustParsePage(append(shared, "templates/admin_scim.html")...),
+		"audit.html":            mustParsePage(append(shared, "templates/audit.html")...),
+		"issue.html":            mustParsePage(append(shared, "templates/issue.html")...),
+		"search.html":           mustParsePage(append(shared, "templates/search.html")...),
+		"project_doc.html":      mustParsePage(append(shared, "templates/project_doc.html")...),
 		"login.html":            mustParsePage("templates/layout.html", "templates/login.html"),
 		"login_sent.html":       mustParsePage("templates/layout.html", "templates/login_sent.html"),
 		"approve.html":          mustParsePage("templates/layout.html", "templates/approve.html"),
+		"invite.html":           mustParsePage("templates/layout.html", "templates/invite.html"),
+		"totp.html":             mustParsePage("templates/layout.html", "templates/totp.html"),
 		// HTMX partials
-		"issues_table": mustParsePage("templates/issues_tab.html"),
+		"issues_table": mustParsePage("templates/layout.html", "templates/issues_tab.html"),
+		"board":        mustParsePage("templates/layout.html", "templates/board_tab.html"),
 	}
 }
 
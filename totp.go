@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238 defaults understood by every authenticator app)
+const (
+	totpPeriod        = 30
+	totpDigits        = 6
+	totpSkew          = 1 // accepted steps before/after the current one
+	recoveryCodeCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func generateTOTPSecret() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return totpEncoding.EncodeToString(b)
+}
+
+func totpCode(secret string, step int64) string {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return ""
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", bin%1000000)
+}
+
+// validateTOTP checks code against the secret allowing for clock skew. It
+// returns the matched time step so callers can reject replays.
+func validateTOTP(secret, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for i := int64(-totpSkew); i <= totpSkew; i++ {
+		step := current + i
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func totpURI(email, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", "Portal")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape("Portal:"+email) + "?" + v.Encode()
+}
+
+// formatTOTPSecret groups the secret in blocks of four for manual entry.
+func formatTOTPSecret(secret string) string {
+	var parts []string
+	for i := 0; i < len(secret); i += 4 {
+		end := i + 4
+		if end > len(secret) {
+			end = len(secret)
+		}
+		parts = append(parts, secret[i:end])
+	}
+	return strings.Join(parts, " ")
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// regenerateRecoveryCodes replaces the user's recovery codes and returns the
+// new plaintext codes. Only their hashes are stored.
+func regenerateRecoveryCodes(userID int64) []string {
+	db.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID)
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		rand.Read(b)
+		h := hex.EncodeToString(b)
+		codes[i] = h[:5] + "-" + h[5:]
+		db.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hashRecoveryCode(codes[i]))
+	}
+	return codes
+}
+
+func remainingRecoveryCodes(userID int64) int {
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&n)
+	return n
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery
+// code for an enrolled user.
+func verifySecondFactor(userID int64, code string) bool {
+	var secret string
+	var lastStep int64
+	err := db.QueryRow("SELECT totp_secret, totp_last_step FROM users WHERE id = ? AND totp_enabled = 1", userID).
+		Scan(&secret, &lastStep)
+	if err != nil {
+		return false
+	}
+	if step, ok := validateTOTP(secret, code, time.Now()); ok {
+		if step <= lastStep {
+			return false
+		}
+		db.Exec("UPDATE users SET totp_last_step = ? WHERE id = ?", step, userID)
+		return true
+	}
+	res, _ := db.Exec("UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		userID, hashRecoveryCode(code))
+	if res != nil {
+		if n, _ := res.RowsAffected(); n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// totpRequired reports whether a login for u must pass the TOTP step.
+func totpRequired(u *User) bool {
+	return u.TOTPEnabled || (u.Role == "admin" && cfg.RequireAdminTOTP)
+}
+
+// confirmTOTPEnrollment enables TOTP for the user once they prove their app
+// produces codes for the pending secret.
+func confirmTOTPEnrollment(userID int64, code string) bool {
+	var secret string
+	err := db.QueryRow("SELECT totp_secret FROM users WHERE id = ? AND totp_enabled = 0 AND totp_secret != ''", userID).
+		Scan(&secret)
+	if err != nil {
+		return false
+	}
+	step, ok := validateTOTP(secret, code, time.Now())
+	if !ok {
+		return false
+	}
+	db.Exec("UPDATE users SET totp_enabled = 1, totp_last_step = ? WHERE id = ?", step, userID)
+	return true
+}
+
+// pendingTOTPSecret returns the user's not-yet-confirmed secret, creating one
+// if needed.
+func pendingTOTPSecret(userID int64) string {
+	var secret string
+	db.QueryRow("SELECT totp_secret FROM users WHERE id = ? AND totp_enabled = 0", userID).Scan(&secret)
+	if secret == "" {
+		secret = generateTOTPSecret()
+		db.Exec("UPDATE users SET totp_secret = ? WHERE id = ? AND totp_enabled = 0", secret, userID)
+	}
+	return secret
+}
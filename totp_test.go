@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// enableTOTP turns TOTP on for the user and returns the secret.
+func enableTOTP(t *testing.T, userID int64) string {
+	t.Helper()
+	secret := generateTOTPSecret()
+	if _, err := db.Exec("UPDATE users SET totp_secret = ?, totp_enabled = 1 WHERE id = ?", secret, userID); err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+func postTOTP(token, code string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/auth/totp?token="+token, strings.NewReader(url.Values{"code": {code}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handleTOTP(rec, req)
+	return rec
+}
+
+func pollStatus(token string) string {
+	rec := httptest.NewRecorder()
+	handleAuthStatus(rec, httptest.NewRequest("GET", "/auth/status?token="+token, nil))
+	return strings.TrimSpace(rec.Body.String())
+}
+
+func sessionCount() int {
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM sessions").Scan(&n)
+	return n
+}
+
+func TestTOTPAttemptsAreCapped(t *testing.T) {
+	openTestDB(t)
+	initTemplates()
+	users := seedProject(t)
+	secret := enableTOTP(t, users["owner"].ID)
+	token := strings.TrimPrefix(beginSecondFactor(users["owner"].Email), "/auth/totp?token=")
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			postTOTP(token, "000000")
+		}()
+	}
+	wg.Wait()
+	var attempts int
+	db.QueryRow("SELECT mfa_attempts FROM magic_tokens WHERE token = ?", token).Scan(&attempts)
+	if attempts > maxCodeAttempts {
+		t.Errorf("%d attempts counted, the cap is %d", attempts, maxCodeAttempts)
+	}
+
+	db.Exec("UPDATE magic_tokens SET mfa_attempts = ? WHERE token = ?", maxCodeAttempts, token)
+	postTOTP(token, totpCode(secret, time.Now().Unix()/totpPeriod))
+	if sessionCount() != 0 {
+		t.Error("a correct code was accepted after the last attempt")
+	}
+}
+
+func TestSecondFactorTokenLogsInOnce(t *testing.T) {
+	openTestDB(t)
+	initTemplates()
+	users := seedProject(t)
+	secret := enableTOTP(t, users["owner"].ID)
+	token := strings.TrimPrefix(beginSecondFactor(users["owner"].Email), "/auth/totp?token=")
+
+	if got := pollStatus(token); !strings.Contains(got, `"approved":false`) {
+		t.Errorf("status before TOTP: %s", got)
+	}
+	res := postTOTP(token, totpCode(secret, time.Now().Unix()/totpPeriod))
+	if res.Code != http.StatusSeeOther || sessionCount() != 1 {
+		t.Fatalf("TOTP step: status %d, %d sessions", res.Code, sessionCount())
+	}
+	if got := pollStatus(token); !strings.Contains(got, `"approved":false`) {
+		t.Errorf("status after TOTP: %s", got)
+	}
+	postTOTP(token, totpCode(secret, time.Now().Unix()/totpPeriod+1))
+	if n := sessionCount(); n != 1 {
+		t.Errorf("%d sessions from one second-factor token", n)
+	}
+}
+
+func TestMagicLinkStatusLogsInOnce(t *testing.T) {
+	openTestDB(t)
+	users := seedProject(t)
+	token, _ := createMagicToken(users["member"].Email)
+	if got := pollStatus(token); got != `{"approved":false}` {
+		t.Errorf("status before approval: %s", got)
+	}
+	db.Exec("UPDATE magic_tokens SET approved_at = CURRENT_TIMESTAMP WHERE token = ?", token)
+	if got := pollStatus(token); got != `{"approved":true}` {
+		t.Errorf("first status after approval: %s", got)
+	}
+	if got := pollStatus(token); got != `{"approved":false}` {
+		t.Errorf("second status after approval: %s", got)
+	}
+	if n := sessionCount(); n != 1 {
+		t.Errorf("%d sessions from one magic link", n)
+	}
+}